@@ -7,6 +7,7 @@ import (
 
 	"litemall/backend/web/controller"
 	"litemall/common"
+	"litemall/config"
 	"litemall/repository"
 	"litemall/service"
 
@@ -15,6 +16,13 @@ import (
 )
 
 func main() {
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal(err)
+	}
+	common.SetMySQLDSN(cfg.MySQL.DSN)
+
 	// 创建 iris 实例
 	app := iris.New()
 
@@ -58,9 +66,16 @@ func main() {
 	order.Register(ctx, orderService)
 	order.Handle(new(controller.OrderController))
 
+	campaignRepository := repository.NewCampaignManager("campaign", db)
+	campaignService := service.NewCampaignService(campaignRepository)
+	campaignParty := app.Party("/campaign")
+	campaign := mvc.New(campaignParty)
+	campaign.Register(ctx, campaignService)
+	campaign.Handle(new(controller.CampaignController))
+
 	// 启动服务
 	app.Run(
-		iris.Addr("localhost:8080"),
+		iris.Addr(cfg.Backend.Addr),
 		iris.WithoutServerError(iris.ErrServerClosed),
 		iris.WithOptimizations,
 	)
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"litemall/common"
+	"litemall/model"
+	"litemall/service"
+
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/mvc"
+)
+
+// CampaignController 秒杀活动对外控制
+type CampaignController struct {
+	Ctx             iris.Context
+	CampaignService *service.CampaignService
+}
+
+// campaignTimeLayout 表单中 datetime-local 的时间格式
+const campaignTimeLayout = "2006-01-02T15:04"
+
+// GetList 获取秒杀活动列表
+func (c *CampaignController) GetList() mvc.View {
+	campaignList, err := c.CampaignService.GetAllCampaign()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	return mvc.View{
+		Name: "campaign/view.html",
+		Data: iris.Map{
+			"campaignList": campaignList,
+		},
+	}
+}
+
+// GetAdd 添加秒杀活动
+func (c *CampaignController) GetAdd() mvc.View {
+	return mvc.View{
+		Name: "campaign/add.html",
+	}
+}
+
+// PostAdd 添加秒杀活动
+func (c *CampaignController) PostAdd() {
+	campaign, err := c.decodeCampaign()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+
+	_, err = c.CampaignService.InsertCampaign(campaign)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+
+	c.Ctx.Redirect("/campaign/list")
+}
+
+// GetManager 管理秒杀活动
+func (c *CampaignController) GetManager() mvc.View {
+	idString := c.Ctx.URLParam("id")
+	id, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	campaign, err := c.CampaignService.GetCampaignByID(id)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+
+	return mvc.View{
+		Name: "campaign/manager.html",
+		Data: iris.Map{
+			"campaign": campaign,
+		},
+	}
+}
+
+// PostUpdate 修改秒杀活动
+func (c *CampaignController) PostUpdate() {
+	campaign, err := c.decodeCampaign()
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+
+	err = c.CampaignService.UpdateCampaign(campaign)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+
+	c.Ctx.Redirect("/campaign/list")
+}
+
+// GetDelete 删除秒杀活动
+func (c *CampaignController) GetDelete() {
+	idString := c.Ctx.URLParam("id")
+	id, err := strconv.ParseInt(idString, 10, 64)
+	if err != nil {
+		c.Ctx.Application().Logger().Debug(err)
+	}
+	ok := c.CampaignService.DeleteCampaignByID(id)
+	if ok {
+		c.Ctx.Application().Logger().Debug("删除秒杀活动成功, id: ", id)
+	} else {
+		c.Ctx.Application().Logger().Debug("删除秒杀活动失败, id: ", id)
+	}
+
+	c.Ctx.Redirect("/campaign/list")
+}
+
+// decodeCampaign 解析表单中的秒杀活动
+func (c *CampaignController) decodeCampaign() (*model.Campaign, error) {
+	campaign := new(model.Campaign)
+	c.Ctx.Request().ParseForm()
+	dec := common.NewDecoder(&common.DecoderOptions{
+		TagName: "imooc",
+	})
+	// 表单时间精确到分钟，按本地时区解析
+	dec.RegisterCustomType(func(values []string) (interface{}, error) {
+		return time.ParseInLocation(campaignTimeLayout, values[0], time.Local)
+	}, []interface{}{time.Time{}}, nil)
+
+	err := dec.Decode(c.Ctx.Request().Form, campaign)
+	return campaign, err
+}
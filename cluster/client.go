@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 每个节点保持的空闲连接数，秒杀时节点之间请求量很大
+const maxIdleConnsPerHost = 64
+
+// PeerClient 节点之间的 HTTP 客户端
+// 所有请求共享连接池，每次尝试有独立的超时时间，并随调用方的 ctx 取消，
+// 请求使用集群密钥签名，对端以 RequestVerifier 校验
+// 节点之间的请求会修改购买记录和库存，不是幂等的，
+// 因此只在连接未建立时重试，请求已发出后超时不重试
+type PeerClient struct {
+	client *http.Client
+	// 集群密钥
+	secret []byte
+	// 每次尝试的超时时间
+	timeout time.Duration
+	// 最多重试次数
+	retries int
+	// 重试间隔
+	backoff time.Duration
+}
+
+// NewPeerClient 创建节点客户端，secret 为集群密钥
+func NewPeerClient(secret []byte, timeout time.Duration, retries int) *PeerClient {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = 90 * time.Second
+	return &PeerClient{
+		client:  &http.Client{Transport: transport},
+		secret:  secret,
+		timeout: timeout,
+		retries: retries,
+		backoff: 50 * time.Millisecond,
+	}
+}
+
+// Get 发送签名的 GET 请求，返回状态码和响应内容
+// 请求参数必须全部放在 url 的查询参数中
+func (p *PeerClient) Get(ctx context.Context, url string) (status int, body []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		status, body, err = p.get(ctx, url)
+		if err == nil || attempt >= p.retries || !IsDialError(err) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(p.backoff << attempt):
+		}
+	}
+}
+
+// get 发送一次请求
+func (p *PeerClient) get(ctx context.Context, url string) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	SignRequest(p.secret, req)
+	response, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return response.StatusCode, body, nil
+}
+
+// IsDialError 判断是否为建立连接失败，此时请求还没有发出
+func IsDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health 节点健康检查
+// 请求节点超时或连接被拒绝时标记为可疑，可疑节点不再分配请求，
+// 连续探测成功一定次数后恢复
+type Health struct {
+	// 集群统一端口
+	port string
+	// 可疑节点，值为连续探测成功次数
+	suspects map[string]int
+	// 恢复所需的连续探测成功次数
+	rise int
+	// 探测间隔
+	interval time.Duration
+	client   *http.Client
+	sync.RWMutex
+}
+
+// NewHealth 创建节点健康检查
+func NewHealth(port string, rise int, interval time.Duration) *Health {
+	return &Health{
+		port:     port,
+		suspects: make(map[string]int),
+		rise:     rise,
+		interval: interval,
+		client:   &http.Client{Timeout: interval},
+	}
+}
+
+// Suspect 标记节点为可疑
+func (h *Health) Suspect(host string) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.suspects[host]; !ok {
+		fmt.Println("节点标记为可疑:", host)
+	}
+	h.suspects[host] = 0
+}
+
+// Healthy 判断节点是否可以分配请求
+func (h *Health) Healthy(host string) bool {
+	h.RLock()
+	defer h.RUnlock()
+	_, ok := h.suspects[host]
+	return !ok
+}
+
+// Run 定期探测可疑节点，直到 ctx 结束
+func (h *Health) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.probeAll()
+		}
+	}
+}
+
+// HandleHealth 健康检查接口
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// probeAll 探测所有可疑节点
+func (h *Health) probeAll() {
+	h.RLock()
+	hosts := make([]string, 0, len(h.suspects))
+	for host := range h.suspects {
+		hosts = append(hosts, host)
+	}
+	h.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			h.record(host, h.probe(host))
+		}(host)
+	}
+	wg.Wait()
+}
+
+// probe 探测节点是否可用
+func (h *Health) probe(host string) bool {
+	response, err := h.client.Get("http://" + host + ":" + h.port + HealthPath)
+	if err != nil {
+		return false
+	}
+	defer response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
+
+// record 记录探测结果，连续成功 rise 次后恢复节点
+func (h *Health) record(host string, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+	count, suspect := h.suspects[host]
+	if !suspect {
+		return
+	}
+	if !ok {
+		h.suspects[host] = 0
+		return
+	}
+	if count+1 >= h.rise {
+		delete(h.suspects, host)
+		fmt.Println("节点恢复:", host)
+		return
+	}
+	h.suspects[host] = count + 1
+}
@@ -0,0 +1,344 @@
+// Package cluster 网关集群管理
+// 节点之间通过 HTTP 心跳交换成员列表，成员加入或失效时自动更新一致性hash环，
+// 心跳和离开消息使用集群密钥签名，未签名或过期的消息被拒绝
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"litemall/common"
+)
+
+// 集群接口路径
+const (
+	HeartbeatPath = "/cluster/heartbeat"
+	LeavePath     = "/cluster/leave"
+	MembersPath   = "/cluster/members"
+	HealthPath    = "/cluster/health"
+)
+
+// heartbeat 心跳消息
+type heartbeat struct {
+	// 发送方地址
+	Host string `json:"host"`
+	// 发送时间，Unix 纳秒，超过心跳超时时间的消息视为重放
+	Time int64 `json:"time"`
+	// 发送方所知的成员，key 为成员地址
+	Members map[string]peer `json:"members"`
+}
+
+// peer 心跳中转述的成员信息
+type peer struct {
+	// 最近一次直接联系的时间，Unix 纳秒
+	Seen int64 `json:"seen"`
+	// hash环权重
+	Weight int `json:"weight"`
+}
+
+// Member 集群成员信息
+type Member struct {
+	Host     string    `json:"host"`
+	Weight   int       `json:"weight"`
+	LastSeen time.Time `json:"last_seen"`
+	Self     bool      `json:"self"`
+}
+
+// Membership 集群成员管理
+// 只有直接收到对方心跳或向对方发送心跳成功才会刷新最近联系时间，
+// 转述的成员信息取较新的时间，避免已下线的节点被相互转述而无法过期
+type Membership struct {
+	// 本机地址
+	self string
+	// 本机权重
+	weight int
+	// 集群统一端口
+	port string
+	// 种子节点，未加入成员列表时持续尝试联系
+	seeds []string
+	// 一致性hash环
+	ring *common.Consistent
+	// key 为成员地址，不包含本机
+	members map[string]*Member
+	// 心跳间隔
+	interval time.Duration
+	// 超过该时间未联系视为下线，也是消息时间允许的偏差
+	timeout time.Duration
+	// 集群密钥，用于消息签名
+	secret []byte
+	client *http.Client
+	sync.RWMutex
+}
+
+// NewMembership 创建集群成员管理，本机立即以 weight 权重加入hash环
+// secret 为集群密钥，集群内各节点必须一致
+func NewMembership(self string, weight int, port string, seeds []string, ring *common.Consistent, interval, timeout time.Duration, secret []byte) (*Membership, error) {
+	if err := ring.AddWithWeight(self, weight); err != nil {
+		return nil, err
+	}
+	return &Membership{
+		self:     self,
+		weight:   weight,
+		port:     port,
+		seeds:    seeds,
+		ring:     ring,
+		members:  make(map[string]*Member),
+		interval: interval,
+		timeout:  timeout,
+		secret:   secret,
+		client:   &http.Client{Timeout: interval},
+	}, nil
+}
+
+// Run 定期发送心跳并清理失效成员，ctx 结束时通知其它成员本机离开
+func (m *Membership) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.broadcast()
+	for {
+		select {
+		case <-ctx.Done():
+			m.leave()
+			return
+		case <-ticker.C:
+			m.broadcast()
+			m.expire()
+		}
+	}
+}
+
+// Members 获取当前成员列表，包含本机
+func (m *Membership) Members() []Member {
+	m.RLock()
+	members := make([]Member, 0, len(m.members)+1)
+	for _, member := range m.members {
+		members = append(members, *member)
+	}
+	m.RUnlock()
+	members = append(members, Member{Host: m.self, Weight: m.weight, LastSeen: time.Now(), Self: true})
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Host < members[j].Host
+	})
+	return members
+}
+
+// HandleHeartbeat 接收其它节点的心跳，返回本机所知的成员列表
+// 新节点向种子节点发送的第一个心跳即为加入集群
+func (m *Membership) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	message, err := m.readMessage(r)
+	if err != nil {
+		fmt.Println("集群心跳校验失败:", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	m.merge(message.Members)
+	m.touch(message.Host, time.Now(), message.Members[message.Host].Weight)
+
+	reply, err := json.Marshal(m.heartbeat())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(SignatureHeader, sign(m.secret, reply))
+	w.Write(reply)
+}
+
+// HandleLeave 处理节点主动离开
+func (m *Membership) HandleLeave(w http.ResponseWriter, r *http.Request) {
+	message, err := m.readMessage(r)
+	if err != nil {
+		fmt.Println("集群离开消息校验失败:", r.RemoteAddr, err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	m.remove(message.Host, "主动离开")
+	w.Write([]byte("true"))
+}
+
+// readMessage 读取并校验其它节点发送的消息
+func (m *Membership) readMessage(r *http.Request) (*heartbeat, error) {
+	body, err := readSigned(m.secret, r.Header, r.Body)
+	if err != nil {
+		return nil, err
+	}
+	message := &heartbeat{}
+	if err = json.Unmarshal(body, message); err != nil {
+		return nil, err
+	}
+	if message.Host == "" {
+		return nil, errors.New("缺少发送方地址")
+	}
+	if !fresh(message.Time, m.timeout) {
+		return nil, ErrStale
+	}
+	return message, nil
+}
+
+// HandleMembers 返回当前hash环成员
+func (m *Membership) HandleMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.Members())
+}
+
+// heartbeat 生成本机的心跳消息
+func (m *Membership) heartbeat() *heartbeat {
+	m.RLock()
+	defer m.RUnlock()
+	members := make(map[string]peer, len(m.members)+1)
+	for host, member := range m.members {
+		members[host] = peer{Seen: member.LastSeen.UnixNano(), Weight: member.Weight}
+	}
+	now := time.Now().UnixNano()
+	members[m.self] = peer{Seen: now, Weight: m.weight}
+	return &heartbeat{Host: m.self, Time: now, Members: members}
+}
+
+// broadcast 向所有成员及尚未加入的种子节点发送心跳
+func (m *Membership) broadcast() {
+	targets := make(map[string]struct{})
+	m.RLock()
+	for host := range m.members {
+		targets[host] = struct{}{}
+	}
+	m.RUnlock()
+	for _, host := range m.seeds {
+		targets[host] = struct{}{}
+	}
+	delete(targets, m.self)
+
+	message, err := json.Marshal(m.heartbeat())
+	if err != nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for host := range targets {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			reply, err := m.send(host, HeartbeatPath, message)
+			if err != nil {
+				return
+			}
+			m.merge(reply.Members)
+			// 种子节点可能以其它地址配置，例如 127.0.0.1，以应答中对方的地址记录
+			if reply.Host != "" {
+				m.touch(reply.Host, time.Now(), reply.Members[reply.Host].Weight)
+			}
+		}(host)
+	}
+	wg.Wait()
+}
+
+// leave 通知其它成员本机离开
+func (m *Membership) leave() {
+	message, err := json.Marshal(&heartbeat{Host: m.self, Time: time.Now().UnixNano()})
+	if err != nil {
+		return
+	}
+	for _, member := range m.Members() {
+		if !member.Self {
+			m.send(member.Host, LeavePath, message)
+		}
+	}
+}
+
+// send 向指定节点发送签名消息，心跳的应答同样校验签名
+func (m *Membership) send(host, path string, message []byte) (*heartbeat, error) {
+	hostURL := "http://" + host + ":" + m.port + path
+	request, err := http.NewRequest(http.MethodPost, hostURL, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(SignatureHeader, sign(m.secret, message))
+	response, err := m.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s 返回状态 %d", host, response.StatusCode)
+	}
+	reply := &heartbeat{}
+	if path == HeartbeatPath {
+		body, err := readSigned(m.secret, response.Header, response.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(body, reply); err != nil {
+			return nil, err
+		}
+	}
+	return reply, nil
+}
+
+// touch 刷新成员最近联系时间，新成员加入hash环，权重变化时更新hash环
+func (m *Membership) touch(host string, seen time.Time, weight int) {
+	if host == m.self || time.Since(seen) > m.timeout {
+		return
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	m.Lock()
+	defer m.Unlock()
+	member, ok := m.members[host]
+	if !ok {
+		m.members[host] = &Member{Host: host, Weight: weight, LastSeen: seen}
+		m.ring.AddWithWeight(host, weight)
+		fmt.Println("集群成员加入:", host, "权重:", weight)
+		return
+	}
+	if !seen.After(member.LastSeen) {
+		return
+	}
+	member.LastSeen = seen
+	if member.Weight != weight {
+		member.Weight = weight
+		m.ring.UpdateWeight(host, weight)
+		fmt.Println("集群成员权重变化:", host, "权重:", weight)
+	}
+}
+
+// merge 合并其它节点转述的成员列表
+func (m *Membership) merge(members map[string]peer) {
+	for host, member := range members {
+		m.touch(host, time.Unix(0, member.Seen), member.Weight)
+	}
+}
+
+// expire 清理超时未联系的成员
+func (m *Membership) expire() {
+	m.RLock()
+	var expired []string
+	for host, member := range m.members {
+		if time.Since(member.LastSeen) > m.timeout {
+			expired = append(expired, host)
+		}
+	}
+	m.RUnlock()
+	for _, host := range expired {
+		m.remove(host, "心跳超时")
+	}
+}
+
+// remove 移除成员并从hash环中删除
+func (m *Membership) remove(host, reason string) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.members[host]; !ok {
+		return
+	}
+	delete(m.members, host)
+	m.ring.Remove(host)
+	fmt.Println("集群成员离开:", host, reason)
+}
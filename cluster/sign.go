@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"litemall/common"
+)
+
+const (
+	// SignatureHeader 集群消息签名，值为消息体的 HMAC-SHA256 十六进制编码
+	SignatureHeader = "X-Cluster-Signature"
+	// TimeHeader 节点之间 HTTP 请求的发送时间，Unix 纳秒
+	TimeHeader = "X-Cluster-Time"
+	// requestWindow 节点之间 HTTP 请求的发送时间与本机时间允许的最大偏差
+	requestWindow = 5 * time.Second
+	// maxMessageSize 集群消息最大长度
+	maxMessageSize = 1 << 20
+)
+
+var (
+	// ErrSignature 签名错误，集群密钥不一致或数据被篡改
+	ErrSignature = errors.New("集群消息签名错误")
+	// ErrStale 消息时间超出允许范围，可能是重放的旧消息
+	ErrStale = errors.New("集群消息已过期")
+)
+
+// sign 计算消息体签名
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readSigned 读取消息体并校验签名
+func readSigned(secret []byte, header http.Header, body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, maxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	expected, err := hex.DecodeString(header.Get(SignatureHeader))
+	if err != nil {
+		return nil, ErrSignature
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return nil, ErrSignature
+	}
+	return data, nil
+}
+
+// fresh 判断消息时间与本机时间的偏差是否在 window 以内
+func fresh(sent int64, window time.Duration) bool {
+	d := time.Since(time.Unix(0, sent))
+	return d <= window && d >= -window
+}
+
+// SignRequest 使用集群密钥签名节点之间的 HTTP 请求
+// 签名覆盖请求方法、路径、查询参数和发送时间，请求参数必须全部放在查询参数中
+func SignRequest(secret []byte, req *http.Request) {
+	sent := strconv.FormatInt(time.Now().UnixNano(), 10)
+	req.Header.Set(TimeHeader, sent)
+	req.Header.Set(SignatureHeader, sign(secret, requestPayload(req, sent)))
+}
+
+// requestPayload 请求的签名内容
+func requestPayload(req *http.Request, sent string) []byte {
+	return []byte(req.Method + " " + req.URL.RequestURI() + " " + sent)
+}
+
+// RequestVerifier 校验节点之间 HTTP 请求的签名
+// 拒绝发送时间超出 requestWindow 的请求，以及窗口内重复的请求
+type RequestVerifier struct {
+	secret []byte
+	// 窗口内收到的请求签名
+	seen map[string]time.Time
+	// 上次清理时间
+	purged time.Time
+	sync.Mutex
+}
+
+// NewRequestVerifier 创建请求校验器，secret 为集群密钥
+func NewRequestVerifier(secret []byte) *RequestVerifier {
+	return &RequestVerifier{
+		secret: secret,
+		seen:   make(map[string]time.Time),
+		purged: time.Now(),
+	}
+}
+
+// Verify 校验请求签名、发送时间，并拒绝重放
+func (v *RequestVerifier) Verify(req *http.Request) error {
+	sentHeader := req.Header.Get(TimeHeader)
+	expected, err := hex.DecodeString(req.Header.Get(SignatureHeader))
+	if err != nil {
+		return ErrSignature
+	}
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write(requestPayload(req, sentHeader))
+	if !hmac.Equal(expected, mac.Sum(nil)) {
+		return ErrSignature
+	}
+	sent, err := strconv.ParseInt(sentHeader, 10, 64)
+	if err != nil || !fresh(sent, requestWindow) {
+		return ErrStale
+	}
+
+	now := time.Now()
+	v.Lock()
+	defer v.Unlock()
+	key := hex.EncodeToString(expected)
+	if _, ok := v.seen[key]; ok {
+		return ErrStale
+	}
+	v.seen[key] = time.Unix(0, sent)
+	if now.Sub(v.purged) > requestWindow {
+		for k, t := range v.seen {
+			if now.Sub(t) > requestWindow {
+				delete(v.seen, k)
+			}
+		}
+		v.purged = now
+	}
+	return nil
+}
+
+// Filter 作为拦截器使用，校验失败时返回 403
+func (v *RequestVerifier) Filter(w http.ResponseWriter, req *http.Request) error {
+	if err := v.Verify(req); err != nil {
+		fmt.Println("集群请求校验失败:", req.RemoteAddr, req.URL.Path, err)
+		return &common.FilterError{Code: http.StatusForbidden, Message: err.Error()}
+	}
+	return nil
+}
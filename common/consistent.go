@@ -3,7 +3,7 @@ package common
 
 import (
 	"errors"
-	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 	"sync"
@@ -26,26 +26,58 @@ func (x units) Swap(i, j int) {
 	x[i], x[j] = x[j], x[i]
 }
 
-// 当hash环上没有数据时 提示错误
-var errEmpty = errors.New("Hash 环没有数据")
+var (
+	// 当hash环上没有数据时 提示错误
+	errEmpty = errors.New("Hash 环没有数据")
+	// 权重必须为正数
+	errWeight = errors.New("节点权重必须大于 0")
+	// 节点不在hash环中
+	errNoNode = errors.New("Hash 环中没有该节点")
+	// 负载系数不能小于 1
+	errLoadFactor = errors.New("负载系数不能小于 1")
+)
 
 // Consistent 创建结构体保存一致性hash信息
 type Consistent struct {
 	// hash环，key为哈希值，值存放节点的信息
 	circle map[uint32]string
+	// 节点权重，key为节点
+	weights map[string]int
+	// 所有节点权重之和
+	totalWeight int
 	// 已经排序的节点hash切片
 	sortedHashes units
-	// 虚拟节点个数，用来增加hash的平衡性
+	// 每单位权重的虚拟节点个数，用来增加hash的平衡性
 	VirtualNode int
+	// hash函数
+	hasher Hasher
 	// map 读写锁
 	sync.RWMutex
+
+	// 有界负载模式的负载系数，为 0 时不启用
+	loadFactor float64
+	// 各节点正在处理的请求数，key为节点
+	loads map[string]int64
+	// 所有节点正在处理的请求数之和
+	totalLoad int64
+	// 负载计数锁，Get 持有读锁时仍需更新负载
+	loadMutex sync.Mutex
 }
 
 // NewConsistent 创建一致性hash算法结构体 设置默认节点数量
+// 使用CRC-32计算hash值
 func NewConsistent() *Consistent {
+	return NewConsistentWithHasher(CRC32Hasher{})
+}
+
+// NewConsistentWithHasher 创建使用指定hash函数的一致性hash算法结构体
+func NewConsistentWithHasher(hasher Hasher) *Consistent {
 	return &Consistent{
+		hasher: hasher,
 		// 初始化变量
-		circle: make(map[uint32]string),
+		circle:  make(map[uint32]string),
+		weights: make(map[string]int),
+		loads:   make(map[string]int64),
 		// 设置虚拟节点个数
 		VirtualNode: 20,
 	}
@@ -59,15 +91,16 @@ func (c *Consistent) generateKey(element string, index int) string {
 
 // 获取hash位置
 func (c *Consistent) hashkey(key string) uint32 {
-	if len(key) < 64 {
-		// 声明一个数组长度为64
-		var srcatch [64]byte
-		// 拷贝数据到数组中
-		copy(srcatch[:], key)
-		// 使用IEEE 多项式返回数据的CRC-32校验和
-		return crc32.ChecksumIEEE(srcatch[:len(key)])
-	}
-	return crc32.ChecksumIEEE([]byte(key))
+	return c.hasher.Hash(key)
+}
+
+// 获取虚拟节点的hash位置
+// 同一节点的虚拟节点key只有末尾序号不同，CRC等hash函数的结果会聚集在环上，
+// 经过 fmix32 打散后各节点分到的数据量与权重成正比。
+// 虚拟节点的位置与未打散时不同，即使数据的hash值不变，大部分数据的归属节点也会变化，
+// 集群内各节点必须使用相同的版本
+func (c *Consistent) virtualKey(element string, index int) uint32 {
+	return fmix32(c.hashkey(c.generateKey(element, index)))
 }
 
 // 更新排序，方便查找
@@ -90,19 +123,62 @@ func (c *Consistent) updateSortedHashes() {
 	c.sortedHashes = hashes
 }
 
-// Add 向hash环中添加节点
+// Add 向hash环中添加节点，权重为 1
 func (c *Consistent) Add(element string) {
+	c.AddWithWeight(element, 1)
+}
+
+// AddWithWeight 向hash环中添加指定权重的节点
+// 虚拟节点个数为 VirtualNode * weight，节点分到的数据量与权重成正比
+// 节点已存在时更新权重
+func (c *Consistent) AddWithWeight(element string, weight int) error {
+	if weight <= 0 {
+		return errWeight
+	}
 	// 加锁
 	c.Lock()
 	// 解锁
 	defer c.Unlock()
-	c.add(element)
+	if _, ok := c.weights[element]; ok {
+		c.remove(element)
+	}
+	c.add(element, weight)
+	return nil
+}
+
+// UpdateWeight 更新已有节点的权重
+func (c *Consistent) UpdateWeight(element string, weight int) error {
+	if weight <= 0 {
+		return errWeight
+	}
+	c.Lock()
+	defer c.Unlock()
+	old, ok := c.weights[element]
+	if !ok {
+		return errNoNode
+	}
+	if old == weight {
+		return nil
+	}
+	c.remove(element)
+	c.add(element, weight)
+	return nil
+}
+
+// Weight 获取节点权重，节点不存在时返回 0
+func (c *Consistent) Weight(element string) int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.weights[element]
 }
 
 // Remove 删除一个节点
 func (c *Consistent) Remove(element string) {
 	c.Lock()
 	defer c.Unlock()
+	if _, ok := c.weights[element]; !ok {
+		return
+	}
 	c.remove(element)
 }
 
@@ -119,25 +195,142 @@ func (c *Consistent) Get(name string) (string, error) {
 	// 计算hash值
 	key := c.hashkey(name)
 	i := c.search(key)
-	return c.circle[c.sortedHashes[i]], nil
+	if c.loadFactor == 0 {
+		return c.circle[c.sortedHashes[i]], nil
+	}
+	return c.getBounded(i), nil
+}
+
+// GetN 根据数据标识顺时针获取 n 个不同的服务器节点，第一个与 Get 关闭有界负载时的结果相同
+// 节点数不足 n 时返回全部节点，用于副本和故障转移
+func (c *Consistent) GetN(name string, n int) ([]string, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.circle) == 0 {
+		return nil, errEmpty
+	}
+	if n > len(c.weights) {
+		n = len(c.weights)
+	}
+	elements := make([]string, 0, n)
+	if n <= 0 {
+		return elements, nil
+	}
+
+	i := c.search(c.hashkey(name))
+	for j := 0; j < len(c.sortedHashes) && len(elements) < n; j++ {
+		element := c.circle[c.sortedHashes[(i+j)%len(c.sortedHashes)]]
+		if !contains(elements, element) {
+			elements = append(elements, element)
+		}
+	}
+	return elements, nil
+}
+
+// 判断切片中是否包含指定节点
+func contains(elements []string, element string) bool {
+	for _, e := range elements {
+		if e == element {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLoadFactor 设置有界负载模式的负载系数，为 0 时关闭
+// 启用后每个节点的负载上限为 平均负载 * 负载系数（按权重折算），
+// Get 选中的节点超过上限时顺时针找下一个未超限的节点
+// 同一数据在负载较高时可能分配到不同节点，只适用于无状态的请求，
+// 按数据保存状态的场景应使用 GetN 获取固定的归属节点
+func (c *Consistent) SetLoadFactor(factor float64) error {
+	if factor != 0 && factor < 1 {
+		return errLoadFactor
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.loadFactor = factor
+	return nil
+}
+
+// Inc 节点开始处理一个请求，与 Done 成对调用
+func (c *Consistent) Inc(element string) {
+	c.loadMutex.Lock()
+	defer c.loadMutex.Unlock()
+	c.loads[element]++
+	c.totalLoad++
+}
+
+// Done 节点处理完一个请求
+func (c *Consistent) Done(element string) {
+	c.loadMutex.Lock()
+	defer c.loadMutex.Unlock()
+	// 节点已被删除时负载已经清零
+	if c.loads[element] <= 0 {
+		return
+	}
+	c.loads[element]--
+	c.totalLoad--
+	if c.loads[element] == 0 {
+		delete(c.loads, element)
+	}
+}
+
+// Load 获取节点正在处理的请求数
+func (c *Consistent) Load(element string) int64 {
+	c.loadMutex.Lock()
+	defer c.loadMutex.Unlock()
+	return c.loads[element]
+}
+
+// 从第i个虚拟节点开始顺时针查找未超过负载上限的节点
+func (c *Consistent) getBounded(i int) string {
+	c.loadMutex.Lock()
+	defer c.loadMutex.Unlock()
+	for j := 0; j < len(c.sortedHashes); j++ {
+		element := c.circle[c.sortedHashes[(i+j)%len(c.sortedHashes)]]
+		if c.loads[element]+1 <= c.maxLoad(element) {
+			return element
+		}
+	}
+	// 负载系数不小于 1 时总有节点未超限，这里只是兜底
+	return c.circle[c.sortedHashes[i]]
+}
+
+// 节点的负载上限，包含即将分配的请求
+func (c *Consistent) maxLoad(element string) int64 {
+	average := float64(c.totalLoad+1) * float64(c.weights[element]) / float64(c.totalWeight)
+	return int64(math.Ceil(average * c.loadFactor))
 }
 
 // 添加节点
-func (c *Consistent) add(element string) {
+func (c *Consistent) add(element string, weight int) {
 	// 循环虚拟节点，设置副本
-	for i := 0; i < c.VirtualNode; i++ {
+	for i := 0; i < c.VirtualNode*weight; i++ {
 		// 根据生成的节点添加到hash环中
-		c.circle[c.hashkey(c.generateKey(element, i))] = element
+		c.circle[c.virtualKey(element, i)] = element
 	}
+	c.weights[element] = weight
+	c.totalWeight += weight
 	// 更新排序
 	c.updateSortedHashes()
 }
 
-// 删除节点
+// 删除节点，虚拟节点个数按添加时的权重计算
 func (c *Consistent) remove(element string) {
-	for i := 0; i < c.VirtualNode; i++ {
-		delete(c.circle, c.hashkey(c.generateKey(element, i)))
+	for i := 0; i < c.VirtualNode*c.weights[element]; i++ {
+		key := c.virtualKey(element, i)
+		// hash冲突时不删除其它节点的虚拟节点
+		if c.circle[key] == element {
+			delete(c.circle, key)
+		}
 	}
+	c.totalWeight -= c.weights[element]
+	delete(c.weights, element)
+	// 删除节点的负载不再计入平均负载
+	c.loadMutex.Lock()
+	c.totalLoad -= c.loads[element]
+	delete(c.loads, element)
+	c.loadMutex.Unlock()
 	c.updateSortedHashes()
 }
 
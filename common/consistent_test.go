@@ -0,0 +1,275 @@
+package common
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// distribution 统计 n 个用户在各节点上的分布
+func distribution(t *testing.T, c *Consistent, n int) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		node, err := c.Get(strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[node]++
+	}
+	return counts
+}
+
+// checkShare 校验各节点分到的比例与权重比例的偏差不超过 tolerance
+func checkShare(t *testing.T, counts map[string]int, weights map[string]int, n int, tolerance float64) {
+	t.Helper()
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	for node, w := range weights {
+		want := float64(w) / float64(total)
+		got := float64(counts[node]) / float64(n)
+		t.Logf("%s weight=%d want=%.3f got=%.3f", node, w, want, got)
+		if math.Abs(got-want)/want > tolerance {
+			t.Errorf("%s 分布 %.3f 与期望 %.3f 偏差超过 %.0f%%", node, got, want, tolerance*100)
+		}
+	}
+}
+
+func TestConsistentWeightedDistribution(t *testing.T) {
+	const n = 100000
+	weights := map[string]int{
+		"192.168.1.101": 1,
+		"192.168.1.102": 2,
+		"192.168.1.103": 3,
+	}
+	c := NewConsistent()
+	c.VirtualNode = 200
+	for node, w := range weights {
+		if err := c.AddWithWeight(node, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkShare(t, distribution(t, c, n), weights, n, 0.15)
+}
+
+func TestConsistentUpdateWeight(t *testing.T) {
+	const n = 100000
+	weights := map[string]int{
+		"192.168.1.101": 1,
+		"192.168.1.102": 1,
+	}
+	c := NewConsistent()
+	c.VirtualNode = 200
+	for node := range weights {
+		c.Add(node)
+	}
+	checkShare(t, distribution(t, c, n), weights, n, 0.15)
+
+	weights["192.168.1.102"] = 3
+	if err := c.UpdateWeight("192.168.1.102", 3); err != nil {
+		t.Fatal(err)
+	}
+	checkShare(t, distribution(t, c, n), weights, n, 0.15)
+
+	// 删除节点时按当前权重删除全部虚拟节点
+	c.Remove("192.168.1.102")
+	if len(c.circle) != c.VirtualNode {
+		t.Errorf("删除节点后剩余虚拟节点 %d，期望 %d", len(c.circle), c.VirtualNode)
+	}
+}
+
+func TestConsistentInvalidWeight(t *testing.T) {
+	c := NewConsistent()
+	if err := c.AddWithWeight("192.168.1.101", 0); err != errWeight {
+		t.Errorf("权重为 0 时应返回 errWeight，得到 %v", err)
+	}
+	if err := c.UpdateWeight("192.168.1.101", 2); err != errNoNode {
+		t.Errorf("更新不存在的节点应返回 errNoNode，得到 %v", err)
+	}
+}
+
+func TestConsistentBoundedLoad(t *testing.T) {
+	nodes := []string{"192.168.1.101", "192.168.1.102", "192.168.1.103"}
+	c := NewConsistent()
+	for _, node := range nodes {
+		c.Add(node)
+	}
+	if err := c.SetLoadFactor(0.5); err != errLoadFactor {
+		t.Errorf("负载系数小于 1 时应返回 errLoadFactor，得到 %v", err)
+	}
+	if err := c.SetLoadFactor(1.25); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个热点用户的请求持续进行中，负载应分散到所有节点
+	const n = 300
+	hot, err := c.Get("hot-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		node, err := c.Get("hot-user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Inc(node)
+	}
+	for _, node := range nodes {
+		// 上限为 ceil(平均负载 * 负载系数)
+		if load := c.Load(node); load > 125 {
+			t.Errorf("%s 负载 %d 超过上限 125", node, load)
+		}
+	}
+	if c.Load(hot) == 0 {
+		t.Errorf("首选节点 %s 没有分配到请求", hot)
+	}
+
+	// 请求结束后恢复为首选节点
+	for _, node := range nodes {
+		for c.Load(node) > 0 {
+			c.Done(node)
+		}
+	}
+	if node, _ := c.Get("hot-user"); node != hot {
+		t.Errorf("负载清零后应返回首选节点 %s，得到 %s", hot, node)
+	}
+
+	// 删除节点后负载不再计入
+	c.Inc(nodes[0])
+	c.Remove(nodes[0])
+	c.Done(nodes[0])
+	if c.totalLoad != 0 {
+		t.Errorf("删除节点后总负载 %d，期望 0", c.totalLoad)
+	}
+}
+
+func TestXX32Hasher(t *testing.T) {
+	// xxHash32 种子为 0 的参考值
+	cases := map[string]uint32{
+		"":    0x02cc5d05,
+		"a":   0x550d7456,
+		"abc": 0x32d153ff,
+		"Nobody inspects the spammish repetition": 0xe2293b2f,
+	}
+	for key, want := range cases {
+		if got := (XX32Hasher{}).Hash(key); got != want {
+			t.Errorf("XX32Hasher(%q) = %#08x，期望 %#08x", key, got, want)
+		}
+	}
+}
+
+func TestCRC32Hasher(t *testing.T) {
+	// 与 crc32.ChecksumIEEE 相同
+	cases := map[string]uint32{
+		"":    0x00000000,
+		"a":   0xe8b7be43,
+		"abc": 0x352441c2,
+		"The quick brown fox jumps over the lazy dog": 0x414fa339,
+	}
+	for key, want := range cases {
+		if got := (CRC32Hasher{}).Hash(key); got != want {
+			t.Errorf("CRC32Hasher(%q) = %#08x，期望 %#08x", key, got, want)
+		}
+	}
+}
+
+func TestFNV1aHasher(t *testing.T) {
+	// 32位FNV-1a的参考值
+	cases := map[string]uint32{
+		"":       0x811c9dc5,
+		"a":      0xe40c292c,
+		"foobar": 0xbf9cf968,
+	}
+	for key, want := range cases {
+		if got := (FNV1aHasher{}).Hash(key); got != want {
+			t.Errorf("FNV1aHasher(%q) = %#08x，期望 %#08x", key, got, want)
+		}
+	}
+}
+
+func TestConsistentHashers(t *testing.T) {
+	const n = 100000
+	weights := map[string]int{
+		"192.168.1.101": 1,
+		"192.168.1.102": 1,
+		"192.168.1.103": 2,
+	}
+	for _, name := range []string{HasherCRC32, HasherCRC32Mix, HasherFNV1a, HasherXX32} {
+		t.Run(name, func(t *testing.T) {
+			hasher, err := NewHasher(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := NewConsistentWithHasher(hasher)
+			c.VirtualNode = 200
+			for node, w := range weights {
+				c.AddWithWeight(node, w)
+			}
+			checkShare(t, distribution(t, c, n), weights, n, 0.15)
+		})
+	}
+	if _, err := NewHasher("md5"); err == nil {
+		t.Error("不支持的hash函数应返回错误")
+	}
+}
+
+func TestConsistentOwners(t *testing.T) {
+	// 固定各用户的归属节点，虚拟节点位置或hash函数变化时集群内归属不一致，
+	// 必须在所有节点同时升级，修改这里的期望值前需确认这一点
+	c := NewConsistent()
+	c.Add("10.0.0.1")
+	c.Add("10.0.0.2")
+	c.AddWithWeight("10.0.0.3", 2)
+	owners := map[string]string{
+		"1":       "10.0.0.3",
+		"2":       "10.0.0.3",
+		"3":       "10.0.0.2",
+		"42":      "10.0.0.2",
+		"1001":    "10.0.0.1",
+		"65536":   "10.0.0.2",
+		"9999999": "10.0.0.1",
+	}
+	for uid, want := range owners {
+		if got, err := c.Get(uid); err != nil || got != want {
+			t.Errorf("用户 %s 分配到 %s，期望 %s", uid, got, want)
+		}
+	}
+}
+
+func TestConsistentGetN(t *testing.T) {
+	nodes := []string{"192.168.1.101", "192.168.1.102", "192.168.1.103"}
+	c := NewConsistent()
+	if _, err := c.GetN("user", 2); err != errEmpty {
+		t.Errorf("空hash环应返回 errEmpty，得到 %v", err)
+	}
+	for _, node := range nodes {
+		c.Add(node)
+	}
+
+	for i := 0; i < 1000; i++ {
+		name := strconv.Itoa(i)
+		replicas, err := c.GetN(name, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		owner, _ := c.Get(name)
+		if len(replicas) != 2 || replicas[0] != owner || replicas[0] == replicas[1] {
+			t.Fatalf("GetN(%q, 2) = %v，首个节点应为 %s 且节点不重复", name, replicas, owner)
+		}
+
+		// 首个节点删除后，原第二个节点成为新的归属节点
+		c.Remove(owner)
+		next, _ := c.Get(name)
+		c.Add(owner)
+		if next != replicas[1] {
+			t.Fatalf("删除 %s 后 %q 分配到 %s，期望 %s", owner, name, next, replicas[1])
+		}
+	}
+
+	all, _ := c.GetN("user", 10)
+	if len(all) != len(nodes) {
+		t.Errorf("节点不足时应返回全部 %d 个节点，得到 %v", len(nodes), all)
+	}
+}
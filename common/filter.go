@@ -1,30 +1,57 @@
 package common
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
+)
 
 // FilterHandle 声明处理函数
 type FilterHandle func(rw http.ResponseWriter, req *http.Request) error
 
+// FilterError 带有 HTTP 状态码的拦截错误
+type FilterError struct {
+	Code    int
+	Message string
+}
+
+// Error 实现 error 接口
+func (e *FilterError) Error() string {
+	return e.Message
+}
+
 // Filter 拦截器结构体
 type Filter struct {
-	filterMap map[string]FilterHandle
+	// 同一个 URI 可以注册多个拦截器，按注册顺序执行
+	filterMap map[string][]FilterHandle
 }
 
 // NewFilter 新建拦截器实例
 func NewFilter() *Filter {
 	return &Filter{
-		filterMap: make(map[string]FilterHandle),
+		filterMap: make(map[string][]FilterHandle),
 	}
 }
 
 // RegisterFilterURI 注册拦截器
 func (f *Filter) RegisterFilterURI(uri string, handle FilterHandle) {
-	f.filterMap[uri] = handle
+	f.filterMap[uri] = append(f.filterMap[uri], handle)
 }
 
 // GetFilterHandle 根据 URI 获取拦截器
+// 多个拦截器会被合并为一个，遇到错误即停止
 func (f *Filter) GetFilterHandle(uri string) FilterHandle {
-	return f.filterMap[uri]
+	handles, ok := f.filterMap[uri]
+	if !ok {
+		return nil
+	}
+	return func(rw http.ResponseWriter, req *http.Request) error {
+		for _, handle := range handles {
+			if err := handle(rw, req); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 }
 
 // WebHandle 声明 web 处理函数
@@ -33,15 +60,17 @@ type WebHandle func(rw http.ResponseWriter, req *http.Request)
 // Handle 执行拦截器 返回函数类型
 func (f *Filter) Handle(webHandle WebHandle) func(rw http.ResponseWriter, req *http.Request) {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		for path, handle := range f.filterMap {
-			if path == req.RequestURI {
-				// 执行拦截业务
-				err := handle(rw, req)
-				if err != nil {
-					rw.Write([]byte(err.Error()))
-					return
+		// 只匹配路径，忽略查询参数
+		if handle := f.GetFilterHandle(req.URL.Path); handle != nil {
+			// 执行拦截业务
+			err := handle(rw, req)
+			if err != nil {
+				var filterErr *FilterError
+				if errors.As(err, &filterErr) {
+					rw.WriteHeader(filterErr.Code)
 				}
-				break
+				rw.Write([]byte(err.Error()))
+				return
 			}
 		}
 
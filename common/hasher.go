@@ -0,0 +1,153 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math/bits"
+)
+
+// Hasher 一致性hash环使用的hash函数
+// 集群中所有节点必须使用相同的hash函数，否则同一用户会分配到不同节点
+type Hasher interface {
+	Hash(key string) uint32
+}
+
+// 支持的hash函数名称
+const (
+	HasherCRC32    = "crc32"
+	HasherCRC32Mix = "crc32mix"
+	HasherFNV1a    = "fnv1a"
+	HasherXX32     = "xxhash32"
+)
+
+// NewHasher 根据名称创建hash函数
+func NewHasher(name string) (Hasher, error) {
+	switch name {
+	case HasherCRC32, "":
+		return CRC32Hasher{}, nil
+	case HasherCRC32Mix:
+		return CRC32MixHasher{}, nil
+	case HasherFNV1a:
+		return FNV1aHasher{}, nil
+	case HasherXX32:
+		return XX32Hasher{}, nil
+	}
+	return nil, errors.New("不支持的hash函数: " + name)
+}
+
+// CRC32Hasher CRC-32校验和，默认使用
+type CRC32Hasher struct{}
+
+// Hash 计算hash值
+func (CRC32Hasher) Hash(key string) uint32 {
+	if len(key) < 64 {
+		// 声明一个数组长度为64
+		var srcatch [64]byte
+		// 拷贝数据到数组中
+		copy(srcatch[:], key)
+		// 使用IEEE 多项式返回数据的CRC-32校验和
+		return crc32.ChecksumIEEE(srcatch[:len(key)])
+	}
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// CRC32MixHasher CRC-32校验和经过 fmix32 打散
+// 用户标识相近时 CRC-32 的结果也相近，打散后分布更均匀，
+// 与 CRC32Hasher 的结果不同，切换时大部分用户会换到其它节点
+type CRC32MixHasher struct{}
+
+// Hash 计算hash值
+func (CRC32MixHasher) Hash(key string) uint32 {
+	return fmix32(CRC32Hasher{}.Hash(key))
+}
+
+// fmix32 打散hash值
+// CRC是线性的，相近的输入生成的hash值会聚集在环上
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// FNV1aHasher 32位FNV-1a
+type FNV1aHasher struct{}
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// Hash 计算hash值
+func (FNV1aHasher) Hash(key string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+// XX32Hasher 种子为 0 的 xxHash32
+type XX32Hasher struct{}
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+// Hash 计算hash值
+func (XX32Hasher) Hash(key string) uint32 {
+	b := []byte(key)
+	n := len(b)
+	var h, seed uint32
+
+	if n >= 16 {
+		// 四路并行处理 16 字节的块
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for len(b) >= 16 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint32(b[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint32(b[4:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint32(b[8:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint32(b[12:]))
+			b = b[16:]
+		}
+		h = bits.RotateLeft32(v1, 1) + bits.RotateLeft32(v2, 7) +
+			bits.RotateLeft32(v3, 12) + bits.RotateLeft32(v4, 18)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint32(n)
+
+	// 处理剩余字节
+	for ; len(b) >= 4; b = b[4:] {
+		h += binary.LittleEndian.Uint32(b) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+	}
+	for ; len(b) > 0; b = b[1:] {
+		h += uint32(b[0]) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxRound(acc, input uint32) uint32 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft32(acc, 13)
+	return acc * xxPrime1
+}
@@ -3,6 +3,8 @@ package common
 import (
 	"errors"
 	"net"
+	"net/http"
+	"strings"
 )
 
 // GetIntranceIP 获取 IP
@@ -23,3 +25,21 @@ func GetIntranceIP() (string, error) {
 
 	return "", errors.New("获取地址异常")
 }
+
+// GetClientIP 获取请求方 IP
+// header 为空时使用连接的对端地址，不信任可被伪造的 X-Forwarded-For 头；
+// 部署在反向代理之后时 header 为代理写入的请求头，例如 X-Real-IP 或 X-Forwarded-For，
+// 取最后一个地址，即最近一级代理看到的对端地址，请求头缺失时使用连接的对端地址
+func GetClientIP(req *http.Request, header string) string {
+	if header != "" {
+		values := strings.Split(req.Header.Get(header), ",")
+		if ip := strings.TrimSpace(values[len(values)-1]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
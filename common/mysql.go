@@ -2,19 +2,55 @@
 package common
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 
-	_ "github.com/go-sql-driver/mysql" // 导入但不使用 init
+	"github.com/go-sql-driver/mysql"
 )
 
+// mysqlDuplicateEntry 唯一索引冲突的错误码
+const mysqlDuplicateEntry = 1062
+
+// mysqlDSN 数据库连接信息，由配置设置
+var mysqlDSN string
+
+// SetMySQLDSN 设置数据库连接信息
+func SetMySQLDSN(dsn string) {
+	mysqlDSN = dsn
+}
+
 // NewMySQLConn 创建 MySQL 连接
 func NewMySQLConn() (db *sql.DB, err error) {
-	dsn := "root:211010@tcp(127.0.0.1:13306)/litemall?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err = sql.Open("mysql", dsn)
+	if mysqlDSN == "" {
+		return nil, errors.New("未配置数据库连接信息")
+	}
+	db, err = sql.Open("mysql", mysqlDSN)
 	return
 }
 
+// IsDuplicateEntry 判断是否为唯一索引冲突
+func IsDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry
+}
+
+// NullString 空字符串转换为 NULL，唯一索引允许多个 NULL
+func NullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// NewRequestID 生成随机请求 ID，用于消息去重
+func NewRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 // GetResultRow 获取返回值,取一条
 func GetResultRow(rows *sql.Rows) map[string]string {
 	columns, _ := rows.Columns()
@@ -0,0 +1,127 @@
+package common
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenBucket 令牌桶
+// 以固定速率生成令牌，桶满后丢弃，每次请求消耗一个令牌
+type TokenBucket struct {
+	// 每秒生成的令牌数
+	rate float64
+	// 桶容量，即允许的突发请求数
+	burst float64
+	// 当前令牌数
+	tokens float64
+	// 上次计算令牌的时间
+	last time.Time
+	sync.Mutex
+}
+
+// NewTokenBucket 创建令牌桶，初始为满桶
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow 尝试获取一个令牌
+func (b *TokenBucket) Allow() bool {
+	return b.AllowAt(time.Now())
+}
+
+// AllowAt 在指定时间尝试获取一个令牌
+func (b *TokenBucket) AllowAt(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	// 补充上次请求以来生成的令牌
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// limiterEntry 令牌桶及最近使用时间
+type limiterEntry struct {
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+// RateLimiter 按 key 分别限流，每个 key 一个令牌桶
+type RateLimiter struct {
+	rate  float64
+	burst int
+	// 超过该时间未使用的令牌桶会被清理
+	idle      time.Duration
+	lastSweep time.Time
+	buckets   map[string]*limiterEntry
+	sync.Mutex
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate,
+		burst:     burst,
+		idle:      10 * time.Minute,
+		lastSweep: time.Now(),
+		buckets:   make(map[string]*limiterEntry),
+	}
+}
+
+// Allow 判断 key 对应的请求是否放行
+func (l *RateLimiter) Allow(key string) bool {
+	now := time.Now()
+	l.Lock()
+	entry, ok := l.buckets[key]
+	if !ok {
+		entry = &limiterEntry{bucket: NewTokenBucket(l.rate, l.burst)}
+		l.buckets[key] = entry
+	}
+	entry.lastSeen = now
+	// 定期清理长时间未使用的令牌桶，防止内存无限增长
+	if now.Sub(l.lastSweep) > l.idle {
+		for k, v := range l.buckets {
+			if now.Sub(v.lastSeen) > l.idle {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	l.Unlock()
+	return entry.bucket.AllowAt(now)
+}
+
+// NewRateLimitFilter 创建按客户端 IP 限流的拦截器，超限返回 429
+// 在身份验证之前执行，ipHeader 为可信代理写入客户端 IP 的请求头，参见 GetClientIP。
+// 按用户限流需要在验证令牌之后进行，uid cookie 未经验证，可以随意更换
+func NewRateLimitFilter(ipLimiter *RateLimiter, ipHeader string) FilterHandle {
+	return func(rw http.ResponseWriter, req *http.Request) error {
+		if !ipLimiter.Allow(GetClientIP(req, ipHeader)) {
+			return TooManyRequests(rw)
+		}
+		return nil
+	}
+}
+
+// TooManyRequests 返回限流错误
+func TooManyRequests(rw http.ResponseWriter) error {
+	rw.Header().Set("Retry-After", "1")
+	return &FilterError{
+		Code:    http.StatusTooManyRequests,
+		Message: "请求过于频繁，请稍后再试",
+	}
+}
@@ -1,28 +1,63 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"litemall/common"
+	"litemall/config"
 	"litemall/rabbitmq"
 	"litemall/repository"
 	"litemall/service"
 )
 
 func main() {
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal(err)
+	}
+	common.SetMySQLDSN(cfg.MySQL.DSN)
+	rabbitmq.MQURL = cfg.RabbitMQ.URL
+
 	db, err := common.NewMySQLConn()
 	if err != nil {
 		fmt.Println(err)
 	}
-	// 创建product数据库操作实例
-	product := repository.NewProductManager("product", db)
-	// 创建product serivce
-	productService := service.NewProductService(product)
 	// 创建Order数据库实例
 	order := repository.NewOrderManager("order", db)
 	// 创建order Service
 	orderService := service.NewOrderService(order)
 
-	rabbitmqConsumeSimple := rabbitmq.NewRabbitMQSimple("imoocProduct")
-	rabbitmqConsumeSimple.ConsumeSimple(orderService, productService)
+	rabbitmqConsumeSimple := rabbitmq.NewRabbitMQSimple(cfg.RabbitMQ.Queue)
+	rabbitmqConsumeSimple.ConfirmTimeout = cfg.RabbitMQ.ConfirmTimeout
+	defer rabbitmqConsumeSimple.Destroy()
+	orderConsumer := rabbitmq.NewOrderConsumer(rabbitmqConsumeSimple, orderService)
+	orderConsumer.MaxRetries = cfg.RabbitMQ.MaxRetries
+	orderConsumer.Concurrency = cfg.RabbitMQ.Concurrency
+	orderConsumer.Prefetch = cfg.RabbitMQ.Prefetch
+	orderConsumer.BatchSize = cfg.RabbitMQ.BatchSize
+	orderConsumer.BatchWait = cfg.RabbitMQ.BatchWait
+
+	// 收到停止信号后停止接收新消息，处理并应答已收到的消息后退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	consumed := make(chan struct{})
+	go func() {
+		orderConsumer.Run(ctx)
+		close(consumed)
+	}()
+
+	<-ctx.Done()
+	fmt.Println("收到停止信号，等待已收到的消息处理完成")
+	select {
+	case <-consumed:
+	case <-time.After(cfg.RabbitMQ.ShutdownTimeout):
+		fmt.Println("等待超时，未应答的消息将重新投递")
+	}
 }
@@ -0,0 +1,56 @@
+// Package main 死信队列工具
+// 查看消费失败转入死信队列的订单消息，或重新发布到订单队列
+//
+//	go run deadletter.go            查看最早的 20 条死信
+//	go run deadletter.go -limit 100 查看最早的 100 条死信
+//	go run deadletter.go -replay 10 重放最早的 10 条死信
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"litemall/config"
+	"litemall/rabbitmq"
+)
+
+func main() {
+	limit := flag.Int("limit", 20, "查看的死信数量")
+	replay := flag.Int("replay", 0, "重新发布到订单队列的死信数量")
+	flag.Parse()
+
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal(err)
+	}
+	rabbitmq.MQURL = cfg.RabbitMQ.URL
+
+	mq := rabbitmq.NewRabbitMQSimple(cfg.RabbitMQ.Queue)
+	mq.ConfirmTimeout = cfg.RabbitMQ.ConfirmTimeout
+	defer mq.Destroy()
+	if err = mq.WaitConnected(10 * time.Second); err != nil {
+		log.Fatal(err)
+	}
+
+	if *replay > 0 {
+		n, err := mq.ReplayDeadLetters(*replay)
+		fmt.Printf("已重新发布 %d 条死信到 %s\n", n, cfg.RabbitMQ.Queue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	letters, err := mq.InspectDeadLetters(*limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s 中最早的 %d 条死信:\n", rabbitmq.DeadLetterQueue(cfg.RabbitMQ.Queue), len(letters))
+	for i, letter := range letters {
+		fmt.Printf("%d\t%s\t原因:%s\t重试:%d\t%s\n", i+1,
+			letter.Time.Format("2006-01-02 15:04:05"), letter.Reason, letter.Retries, letter.Body)
+	}
+}
@@ -8,10 +8,15 @@ import (
 	"crypto/cipher"
 	"encoding/base64"
 	"errors"
+	"strings"
 )
 
-// 16,24,32位字符串的话，分别对应AES-128，AES-192，AES-256 加密方法
-var passwordKey = []byte("DIS**#KKKDJJSKDI")
+// errPadding 填充校验失败
+var errPadding = errors.New("加密字符串错误！")
+
+// LegacyPrefix 旧版 CBC 模式密文的前缀
+// 旧数据本身没有版本标记，调用方确认数据来自升级前时加上该前缀再交给 DePasswordCode
+const LegacyPrefix = "cbc:"
 
 // PKCS7Padding PKCS7 填充模式
 func PKCS7Padding(data []byte, blockSize int) []byte {
@@ -29,15 +34,23 @@ func PKCS7UnPadding(data []byte) ([]byte, error) {
 	length := len(data)
 
 	if length == 0 {
-		return nil, errors.New("加密字符串错误！")
+		return nil, errPadding
 	}
 	// 获取填充字符串长度
 	unpadding := int(data[length-1])
+	// 填充长度必须在 1 到块大小之间，且每个填充字节都等于填充长度
+	if unpadding == 0 || unpadding > aes.BlockSize || unpadding > length {
+		return nil, errPadding
+	}
+	if !bytes.Equal(data[length-unpadding:], bytes.Repeat([]byte{byte(unpadding)}, unpadding)) {
+		return nil, errPadding
+	}
 	// 截取切片，删除填充字节，并且返回明文
 	return data[:(length - unpadding)], nil
 }
 
 // AesEncrypt 加密
+// Deprecated: CBC 模式以密钥作为 IV 且没有完整性校验，新代码请使用 KeyRing
 func AesEncrypt(data, key []byte) ([]byte, error) {
 	// 创建加密算法实例
 	block, err := aes.NewCipher(key)
@@ -57,6 +70,7 @@ func AesEncrypt(data, key []byte) ([]byte, error) {
 }
 
 // AesDecrypt 解密
+// Deprecated: CBC 模式以密钥作为 IV 且没有完整性校验，新代码请使用 KeyRing
 func AesDecrypt(cypted []byte, key []byte) ([]byte, error) {
 	// 创建加密算法实例
 	block, err := aes.NewCipher(key)
@@ -65,6 +79,10 @@ func AesDecrypt(cypted []byte, key []byte) ([]byte, error) {
 	}
 	// 获取块大小
 	blockSize := block.BlockSize()
+	// 密文长度必须是块大小的整数倍，否则解密会 panic
+	if len(cypted) == 0 || len(cypted)%blockSize != 0 {
+		return nil, errPadding
+	}
 	// 创建加密客户端实例
 	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize])
 	data := make([]byte, len(cypted))
@@ -78,22 +96,36 @@ func AesDecrypt(cypted []byte, key []byte) ([]byte, error) {
 	return data, err
 }
 
-// EnPasswordCode 加密 base64
+// EnPasswordCode 使用默认密钥环加密 base64
 func EnPasswordCode(password []byte) (string, error) {
-	result, err := AesEncrypt(password, passwordKey)
+	keyRing, err := DefaultKeyRing()
+	if err != nil {
+		return "", err
+	}
+	result, err := keyRing.Encrypt(password)
 	if err != nil {
 		return "", err
 	}
 	return base64.StdEncoding.EncodeToString(result), err
 }
 
-// DePasswordCode 解密 base64
+// DePasswordCode 使用默认密钥环解密 base64
+// 带有 LegacyPrefix 前缀时使用旧版 CBC 模式的密钥解密，否则只接受密钥环格式，
+// 校验失败时不会再尝试 CBC 模式，避免绕过完整性校验
 func DePasswordCode(password string) ([]byte, error) {
+	keyRing, err := DefaultKeyRing()
+	if err != nil {
+		return nil, err
+	}
+	legacy := strings.HasPrefix(password, LegacyPrefix)
 	// 解密base64字符串
-	passwordByte, err := base64.StdEncoding.DecodeString(password)
+	passwordByte, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(password, LegacyPrefix))
 	if err != nil {
 		return nil, err
 	}
 	// 执行AES解密
-	return AesDecrypt(passwordByte, passwordKey)
+	if legacy {
+		return keyRing.DecryptLegacy(passwordByte)
+	}
+	return keyRing.Decrypt(passwordByte)
 }
@@ -0,0 +1,201 @@
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNoKey 没有可用的密钥
+	ErrNoKey = errors.New("未配置加密密钥")
+	// ErrCiphertext 密文格式错误或已被篡改
+	ErrCiphertext = errors.New("加密字符串已被篡改")
+)
+
+// KeyRing 版本化密钥环
+// 加密使用当前版本的密钥，密文首字节记录密钥版本，
+// 轮换密钥后旧版本密钥加密的数据仍可解密
+// 密文格式：版本(1 字节) | 随机数(12 字节) | AES-GCM 密文及认证标签
+type KeyRing struct {
+	// key 为密钥版本
+	aeads  map[byte]cipher.AEAD
+	active byte
+	// 旧版 CBC 模式的密钥，用于解密密钥环之前加密的数据，为空时不启用
+	legacyKey []byte
+	sync.RWMutex
+}
+
+// NewKeyRing 创建空的密钥环
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		aeads: make(map[byte]cipher.AEAD),
+	}
+}
+
+// AddKey 添加指定版本的密钥
+// 16,24,32位密钥分别对应AES-128，AES-192，AES-256
+func (k *KeyRing) AddKey(version byte, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	k.Lock()
+	defer k.Unlock()
+	k.aeads[version] = aead
+	return nil
+}
+
+// SetLegacyKey 设置旧版 CBC 模式的密钥，只用于解密
+func (k *KeyRing) SetLegacyKey(key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	k.Lock()
+	defer k.Unlock()
+	k.legacyKey = key
+	return nil
+}
+
+// SetActive 设置加密使用的密钥版本
+func (k *KeyRing) SetActive(version byte) error {
+	k.Lock()
+	defer k.Unlock()
+	if _, ok := k.aeads[version]; !ok {
+		return fmt.Errorf("密钥版本 %d 不存在", version)
+	}
+	k.active = version
+	return nil
+}
+
+// Encrypt 使用当前版本密钥加密
+func (k *KeyRing) Encrypt(data []byte) ([]byte, error) {
+	k.RLock()
+	version := k.active
+	aead, ok := k.aeads[version]
+	k.RUnlock()
+	if !ok {
+		return nil, ErrNoKey
+	}
+
+	// 每次加密使用新的随机数
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(data)+aead.Overhead())
+	out[0] = version
+	if _, err := rand.Read(out[1:]); err != nil {
+		return nil, err
+	}
+	// 版本号作为附加数据参与认证，防止被替换
+	return aead.Seal(out, out[1:], data, out[:1]), nil
+}
+
+// Decrypt 根据密文中的版本号选择密钥解密并校验完整性
+func (k *KeyRing) Decrypt(data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, ErrCiphertext
+	}
+	k.RLock()
+	aead, ok := k.aeads[data[0]]
+	k.RUnlock()
+	if !ok {
+		return nil, ErrNoKey
+	}
+	if len(data) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrCiphertext
+	}
+
+	nonce := data[1 : 1+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, data[1+aead.NonceSize():], data[:1])
+	if err != nil {
+		return nil, ErrCiphertext
+	}
+	return plain, nil
+}
+
+// DecryptLegacy 使用旧版 CBC 模式的密钥解密，没有设置时返回 ErrNoKey
+// CBC 模式没有完整性校验，只能用于迁移旧数据
+func (k *KeyRing) DecryptLegacy(data []byte) ([]byte, error) {
+	k.RLock()
+	key := k.legacyKey
+	k.RUnlock()
+	if key == nil {
+		return nil, ErrNoKey
+	}
+	return AesDecrypt(data, key)
+}
+
+// ParseKeyRing 根据配置创建密钥环
+// keys 格式为 "版本:base64密钥,版本:base64密钥"，active 为加密使用的版本，
+// legacyKey 为 base64 编码的旧版 CBC 模式密钥，为空时不启用
+func ParseKeyRing(keys string, active int, legacyKey string) (*KeyRing, error) {
+	keyRing := NewKeyRing()
+	for _, item := range strings.Split(keys, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		versionString, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("密钥配置格式错误: %s", item)
+		}
+		version, err := strconv.ParseUint(versionString, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("密钥版本错误: %s", versionString)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("密钥 %d 不是合法的 base64: %w", version, err)
+		}
+		if err = keyRing.AddKey(byte(version), key); err != nil {
+			return nil, fmt.Errorf("密钥 %d 错误: %w", version, err)
+		}
+	}
+	if active < 0 || active > 255 {
+		return nil, fmt.Errorf("密钥版本错误: %d", active)
+	}
+	if err := keyRing.SetActive(byte(active)); err != nil {
+		return nil, err
+	}
+	if legacyKey != "" {
+		key, err := base64.StdEncoding.DecodeString(legacyKey)
+		if err != nil {
+			return nil, fmt.Errorf("旧版密钥不是合法的 base64: %w", err)
+		}
+		if err = keyRing.SetLegacyKey(key); err != nil {
+			return nil, fmt.Errorf("旧版密钥错误: %w", err)
+		}
+	}
+	return keyRing, nil
+}
+
+var (
+	defaultKeyRing *KeyRing
+	defaultMutex   sync.Mutex
+)
+
+// SetDefaultKeyRing 设置默认密钥环
+func SetDefaultKeyRing(keyRing *KeyRing) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	defaultKeyRing = keyRing
+}
+
+// DefaultKeyRing 获取默认密钥环
+// 密钥只来自配置，未通过 SetDefaultKeyRing 设置时返回 ErrNoKey
+func DefaultKeyRing() (*KeyRing, error) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	if defaultKeyRing == nil {
+		return nil, ErrNoKey
+	}
+	return defaultKeyRing, nil
+}
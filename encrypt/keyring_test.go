@@ -0,0 +1,150 @@
+package encrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// testKey 生成指定长度、字节相同的密钥
+func testKey(b byte, n int) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, n))
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	plain := []byte("13800138000")
+	old, err := ParseKeyRing("1:"+testKey('a', 16), 1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldData, err := old.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 轮换后新数据使用版本 2，版本 1 加密的数据仍可解密
+	keyRing, err := ParseKeyRing("1:"+testKey('a', 16)+", 2:"+testKey('b', 32), 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newData, err := keyRing.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newData[0] != 2 {
+		t.Errorf("轮换后密文版本 %d，期望 2", newData[0])
+	}
+	for _, data := range [][]byte{oldData, newData} {
+		got, err := keyRing.Decrypt(data)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("解密版本 %d 的密文得到 %q, %v", data[0], got, err)
+		}
+	}
+
+	// 删除旧版本后无法解密旧数据
+	if _, err = old.Decrypt(newData); !errors.Is(err, ErrNoKey) {
+		t.Errorf("缺少密钥版本时返回 %v，期望 ErrNoKey", err)
+	}
+	// 篡改版本号或密文后校验失败
+	tampered := append([]byte{}, newData...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err = keyRing.Decrypt(tampered); !errors.Is(err, ErrCiphertext) {
+		t.Errorf("篡改密文后返回 %v，期望 ErrCiphertext", err)
+	}
+	tampered = append([]byte{1}, newData[1:]...)
+	if _, err = keyRing.Decrypt(tampered); !errors.Is(err, ErrCiphertext) {
+		t.Errorf("篡改版本号后返回 %v，期望 ErrCiphertext", err)
+	}
+
+	if _, err = ParseKeyRing("1:"+testKey('a', 16), 2, ""); err == nil {
+		t.Error("当前版本不存在时应返回错误")
+	}
+	if _, err = ParseKeyRing("1:"+testKey('a', 15), 1, ""); err == nil {
+		t.Error("密钥长度错误时应返回错误")
+	}
+}
+
+func TestDePasswordCodeLegacy(t *testing.T) {
+	legacyKey := []byte("DIS**#KKKDJJSKDI")
+	keyRing, err := ParseKeyRing("1:"+testKey('a', 16), 1, base64.StdEncoding.EncodeToString(legacyKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDefaultKeyRing(keyRing)
+	defer SetDefaultKeyRing(nil)
+
+	// 升级前使用 CBC 模式加密的数据，只有带前缀时才按旧版解密
+	legacy, err := AesEncrypt([]byte("legacy"), legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedLegacy := base64.StdEncoding.EncodeToString(legacy)
+	got, err := DePasswordCode(LegacyPrefix + encodedLegacy)
+	if err != nil || string(got) != "legacy" {
+		t.Errorf("解密旧数据得到 %q, %v", got, err)
+	}
+	if _, err = DePasswordCode(encodedLegacy); err == nil {
+		t.Error("没有前缀的旧数据不应按旧版解密")
+	}
+
+	encoded, err := EnPasswordCode([]byte("current"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = DePasswordCode(encoded)
+	if err != nil || string(got) != "current" {
+		t.Errorf("解密新数据得到 %q, %v", got, err)
+	}
+	// 新数据被篡改后校验失败，不会再尝试旧版解密
+	tampered, _ := base64.StdEncoding.DecodeString(encoded)
+	tampered[len(tampered)-1] ^= 1
+	if _, err = DePasswordCode(base64.StdEncoding.EncodeToString(tampered)); !errors.Is(err, ErrCiphertext) {
+		t.Errorf("篡改新数据后返回 %v，期望 ErrCiphertext", err)
+	}
+
+	// 未设置旧版密钥时只接受密钥环格式
+	keyRing, _ = ParseKeyRing("1:"+testKey('a', 16), 1, "")
+	SetDefaultKeyRing(keyRing)
+	if _, err = DePasswordCode(LegacyPrefix + encodedLegacy); !errors.Is(err, ErrNoKey) {
+		t.Error("未设置旧版密钥时不应解密旧数据")
+	}
+}
+
+func TestDefaultKeyRingUnset(t *testing.T) {
+	SetDefaultKeyRing(nil)
+	if _, err := EnPasswordCode([]byte("data")); !errors.Is(err, ErrNoKey) {
+		t.Errorf("未配置密钥时返回 %v，期望 ErrNoKey", err)
+	}
+}
+
+func TestPKCS7UnPadding(t *testing.T) {
+	cases := []struct {
+		data []byte
+		want []byte
+		ok   bool
+	}{
+		{append([]byte("abc"), bytes.Repeat([]byte{13}, 13)...), []byte("abc"), true},
+		{bytes.Repeat([]byte{16}, 16), []byte{}, true},
+		{nil, nil, false},
+		// 填充长度为 0
+		{[]byte("abc\x00"), nil, false},
+		// 填充长度超过块大小
+		{append(bytes.Repeat([]byte{'a'}, 16), bytes.Repeat([]byte{17}, 17)...), nil, false},
+		// 填充长度超过数据长度
+		{[]byte{'a', 5}, nil, false},
+		// 填充字节不一致
+		{[]byte("abcd\x01\x02\x03\x03"), nil, false},
+	}
+	for _, c := range cases {
+		got, err := PKCS7UnPadding(c.data)
+		if (err == nil) != c.ok || !bytes.Equal(got, c.want) {
+			t.Errorf("PKCS7UnPadding(%q) = %q, %v", c.data, got, err)
+		}
+	}
+
+	// 解密长度不是块大小整数倍的数据返回错误而不是 panic
+	if _, err := AesDecrypt([]byte("short"), []byte("DIS**#KKKDJJSKDI")); err == nil {
+		t.Error("密文长度错误时应返回错误")
+	}
+}
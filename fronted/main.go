@@ -3,13 +3,16 @@ package main
 
 import (
 	"context"
+	"log"
 	"time"
 
 	"litemall/common"
+	"litemall/config"
 	"litemall/fronted/middleware"
 	"litemall/fronted/web/controller"
 	"litemall/repository"
 	"litemall/service"
+	"litemall/token"
 
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/mvc"
@@ -17,6 +20,17 @@ import (
 )
 
 func main() {
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 登录时签发会话令牌
+	if err = cfg.CheckToken(); err != nil {
+		log.Fatal(err)
+	}
+	common.SetMySQLDSN(cfg.MySQL.DSN)
+
 	// 创建 iris 实例
 	app := iris.New()
 
@@ -49,11 +63,15 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 会话令牌，注销列表存放在数据库中与网关共享
+	revoker := repository.NewRevocationManager("token_revocation", db)
+	tokenManager := token.NewManager([]byte(cfg.Token.Secret), cfg.Token.TTL, revoker)
+
 	// 注册控制器
 	user := repository.NewUserManager("user", db)
 	userService := service.NewUserService(user)
 	userPro := mvc.New(app.Party("/user"))
-	userPro.Register(userService, ctx, sess.Start)
+	userPro.Register(userService, ctx, sess.Start, tokenManager)
 	userPro.Handle(new(controller.UserController))
 
 	product := repository.NewProductManager("product", db)
@@ -61,12 +79,12 @@ func main() {
 	productService := service.NewProductService(product)
 	orderService := service.NewOrderService(order)
 	productPro := mvc.New(app.Party("/product"))
-	productPro.Router.Use(middleware.AuthConProduct)
+	productPro.Router.Use(middleware.AuthConProduct(tokenManager))
 	productPro.Register(productService, orderService, ctx, sess.Start)
 	productPro.Handle(new(controller.ProductController))
 
 	app.Run(
-		iris.Addr("localhost:8082"),
+		iris.Addr(cfg.Fronted.Addr),
 		iris.WithoutServerError(iris.ErrServerClosed),
 		iris.WithOptimizations,
 	)
@@ -1,19 +1,36 @@
 // Package middleware 各种中间件
 package middleware
 
-import "github.com/kataras/iris/v12"
+import (
+	"strconv"
+
+	"litemall/token"
+
+	"github.com/kataras/iris/v12"
+)
 
 // AuthConProduct 检查是否登录
-func AuthConProduct(ctx iris.Context) {
-	// 从 cookie 中得到用户 id
-	uid := ctx.GetCookie("uid")
+// 校验会话令牌的签名、有效期及注销状态，并要求与 uid cookie 一致
+func AuthConProduct(manager *token.Manager) iris.Handler {
+	return func(ctx iris.Context) {
+		// 从 cookie 中得到用户 id 和令牌
+		uid := ctx.GetCookie("uid")
+		tokenString := ctx.GetCookie("token")
 
-	if uid == "" {
-		ctx.Application().Logger().Debug("必须先登录")
-		ctx.Redirect("/user/login")
-		return
-	}
+		if uid == "" || tokenString == "" {
+			ctx.Application().Logger().Debug("必须先登录")
+			ctx.Redirect("/user/login")
+			return
+		}
 
-	ctx.Application().Logger().Debug("已经登录")
-	ctx.Next()
+		claims, err := manager.Verify(tokenString)
+		if err != nil || strconv.FormatInt(claims.UserID, 10) != uid {
+			ctx.Application().Logger().Debug("登录信息无效: ", err)
+			ctx.Redirect("/user/login")
+			return
+		}
+
+		ctx.Application().Logger().Debug("已经登录")
+		ctx.Next()
+	}
 }
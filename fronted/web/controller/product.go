@@ -1,12 +1,15 @@
 package controller
 
 import (
+	"errors"
 	"html/template"
 	"os"
 	"path/filepath"
 	"strconv"
 
+	"litemall/common"
 	"litemall/model"
+	"litemall/repository"
 	"litemall/service"
 
 	"github.com/kataras/iris/v12"
@@ -108,29 +111,21 @@ func (p *ProductController) GetOrder() mvc.View {
 		p.Ctx.Application().Logger().Debug(err)
 	}
 
-	product, err := p.ProductService.GetProductByID(int64(productID))
-	if err != nil {
-		p.Ctx.Application().Logger().Debug(err)
-	}
-
 	var orderID int64
 	showMessage := "抢购失败"
-	// 判断商品数量是否满足需求
-	if product.Number > 0 {
-		// 扣除商品数量
-		product.Number--
-		p.ProductService.UpdateProduct(product)
-
-		// 创建订单
-		order := &model.Order{
-			UserID:    userID,
-			ProductID: int64(productID),
-			Status:    model.OrderSuccess,
-		}
-		orderID, err = p.OrderService.InsertOrder(order)
-		if err != nil {
+	// 在同一事务中扣除商品数量、创建订单并写入订单创建事件，库存不足时不创建订单
+	requestID, err := common.NewRequestID()
+	if err != nil {
+		p.Ctx.Application().Logger().Debug(err)
+	} else {
+		message := model.NewMessage(userID, int64(productID), requestID)
+		orderID, err = p.OrderService.InsertOrderByMessage(message)
+		switch {
+		case errors.Is(err, repository.ErrSoldOut):
+			showMessage = "商品已售罄"
+		case err != nil:
 			p.Ctx.Application().Logger().Debug(err)
-		} else {
+		default:
 			showMessage = "抢购成功"
 		}
 	}
@@ -2,12 +2,12 @@
 package controller
 
 import (
-	"fmt"
 	"strconv"
+	"time"
 
-	"litemall/encrypt"
 	"litemall/model"
 	"litemall/service"
+	"litemall/token"
 	"litemall/tool"
 
 	"github.com/kataras/iris/v12"
@@ -17,9 +17,10 @@ import (
 
 // UserController 用户控制层
 type UserController struct {
-	Ctx     iris.Context
-	Service service.IUserService
-	Session *sessions.Session
+	Ctx          iris.Context
+	Service      service.IUserService
+	Session      *sessions.Session
+	TokenManager *token.Manager
 }
 
 // GetRegister 注册页面
@@ -74,18 +75,36 @@ func (u *UserController) PostLogin() mvc.Response {
 		}
 	}
 
-	// 3、写入用户ID到cookie中
-	tool.GlobalCookie(u.Ctx, "uid", strconv.FormatInt(user.ID, 10))
-	uidByte := []byte(strconv.FormatInt(user.ID, 10))
-	uidString, err := encrypt.EnPasswordCode(uidByte)
+	// 3、签发会话令牌
+	tokenString, claims, err := u.TokenManager.Issue(user.ID)
 	if err != nil {
-		fmt.Println(err)
+		u.Ctx.Application().Logger().Debug(err)
+		return mvc.Response{
+			Path: "/user/login",
+		}
 	}
 
-	// 4、写入用户 ID 到浏览器
-	tool.GlobalCookie(u.Ctx, "sign", uidString)
+	// 4、写入用户 ID 和令牌到浏览器
+	tool.GlobalCookie(u.Ctx, "uid", strconv.FormatInt(user.ID, 10))
+	tool.GlobalHTTPOnlyCookie(u.Ctx, "token", tokenString, time.Unix(claims.ExpireAt, 0))
 
 	return mvc.Response{
 		Path: "/product/",
 	}
 }
+
+// GetLogout 退出登录
+func (u *UserController) GetLogout() mvc.Response {
+	// 注销令牌，之后即使令牌被截获也无法使用
+	if tokenString := u.Ctx.GetCookie("token"); tokenString != "" {
+		if err := u.TokenManager.Revoke(tokenString); err != nil {
+			u.Ctx.Application().Logger().Debug(err)
+		}
+	}
+	tool.RemoveGlobalCookie(u.Ctx, "uid")
+	tool.RemoveGlobalCookie(u.Ctx, "token")
+
+	return mvc.Response{
+		Path: "/user/login",
+	}
+}
@@ -1,43 +1,638 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"litemall/cluster"
+	"litemall/common"
+	"litemall/config"
+	"litemall/model"
+	"litemall/repository"
+	"litemall/rpc"
+	"litemall/service"
 )
 
-var sum int64
+var (
+	// 默认准入策略，由配置设置
+	defaultPolicy string
+	// 商品库存控制，按商品 ID 分别计数
+	stockControl = &StockControl{
+		stocks: make(map[int64]*ProductStock),
+	}
+	productService  service.IProductService
+	campaignService service.ICampaignService
+)
 
-// 预存商品数量
-var productNum int64 = 1000000
+const (
+	journalSnapshot = "snapshot" // 快照，记录库存与已售数量
+	journalReset    = "reset"    // 重置库存
+	journalSell     = "sell"     // 售出一件
+	journalReturn   = "return"   // 归还一件
+	journalPolicy   = "policy"   // 修改准入策略
+)
 
-// 互斥锁
-var mutex sync.Mutex
+// journalEntry 库存日志记录
+type journalEntry struct {
+	Op         string `json:"op"`
+	ProductID  int64  `json:"product_id"`
+	ProductNum int64  `json:"product_num,omitempty"`
+	Sum        int64  `json:"sum,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+	// 售出时所在的秒杀活动，归还时计入售出时的活动
+	CampaignID int64 `json:"campaign_id,omitempty"`
+	// 售出或归还对应的订单请求 ID
+	RequestID string `json:"request_id,omitempty"`
+	// 快照中各秒杀活动的已售数量，key 为活动 ID
+	Campaigns map[int64]int64 `json:"campaigns,omitempty"`
+}
 
-// 计数
-var count int64
+// StockJournal 库存日志
+// 以追加方式写入本地文件，进程重启后回放日志恢复已售数量，防止超卖
+type StockJournal struct {
+	path string
+	file *os.File
+	sync.Mutex
+}
 
-// GetOneProduct 获取秒杀商品
-func GetOneProduct() bool {
+// stockState 回放日志得到的商品库存状态
+type stockState struct {
+	productNum int64
+	sum        int64
+	policy     string
+	// 各秒杀活动的已售数量
+	campaigns map[int64]int64
+}
+
+// OpenStockJournal 打开库存日志并回放
+// 回放完成后将当前状态压缩为快照，避免日志无限增长
+func OpenStockJournal(path string) (*StockJournal, map[int64]*stockState, error) {
+	states, err := replayJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	journal := &StockJournal{path: path}
+	if err = journal.compact(states); err != nil {
+		return nil, nil, err
+	}
+	return journal, states, nil
+}
+
+// replayJournal 回放日志
+// 末尾未写完整的记录（进程崩溃时产生）会被忽略
+func replayJournal(path string) (map[int64]*stockState, error) {
+	states := make(map[int64]*stockState)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				fmt.Println("忽略未写完整的库存日志:", string(line))
+			}
+			return states, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &journalEntry{}
+		if err := json.Unmarshal(line, entry); err != nil {
+			return nil, fmt.Errorf("库存日志损坏: %w", err)
+		}
+		state, ok := states[entry.ProductID]
+		if !ok {
+			state = &stockState{campaigns: make(map[int64]int64)}
+			states[entry.ProductID] = state
+		}
+		switch entry.Op {
+		case journalSnapshot:
+			state.productNum = entry.ProductNum
+			state.sum = entry.Sum
+			state.policy = entry.Policy
+			for campaignID, sold := range entry.Campaigns {
+				state.campaigns[campaignID] = sold
+			}
+		case journalReset:
+			state.productNum = entry.ProductNum
+			state.sum = 0
+		case journalSell:
+			state.sum++
+			if entry.CampaignID != 0 {
+				state.campaigns[entry.CampaignID]++
+			}
+		case journalReturn:
+			if state.sum > 0 {
+				state.sum--
+			}
+			if state.campaigns[entry.CampaignID] > 0 {
+				state.campaigns[entry.CampaignID]--
+			}
+		case journalPolicy:
+			state.policy = entry.Policy
+		}
+	}
+}
+
+// compact 将状态写成快照文件并替换原日志
+func (j *StockJournal) compact(states map[int64]*stockState) error {
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	for productID, state := range states {
+		entry := &journalEntry{
+			Op:         journalSnapshot,
+			ProductID:  productID,
+			ProductNum: state.productNum,
+			Sum:        state.sum,
+			Policy:     state.policy,
+			Campaigns:  state.campaigns,
+		}
+		if err = writeEntry(writer, entry); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+// writeEntry 写入一条日志记录
+func writeEntry(w io.Writer, entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Append 追加一条日志并落盘
+// 只有落盘成功后才能确认售出，保证重启后不会多卖
+func (j *StockJournal) Append(entry *journalEntry) error {
+	j.Lock()
+	defer j.Unlock()
+	if err := writeEntry(j.file, entry); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Close 关闭日志文件
+func (j *StockJournal) Close() error {
+	return j.file.Close()
+}
+
+// AdmissionPolicy 准入策略，决定一次请求能否获得购买资格
+// 调用时已持有商品库存锁，实现无需自行加锁
+type AdmissionPolicy interface {
+	// Admit 判断用户本次请求是否获得购买资格，库存是否充足由调用方判断
+	// campaign 为进行中的秒杀活动，没有活动时为 nil
+	Admit(uid int64, stock *ProductStock, campaign *model.Campaign) bool
+}
+
+// ParseAdmissionPolicy 根据配置创建准入策略
+// first：先到先得
+// sample:<rate>：按概率抽样，rate 取值 (0, 1]
+// lottery:<window>：秒杀活动开始后的窗口期内报名，窗口关闭时抽签，如 lottery:30s
+func ParseAdmissionPolicy(spec string) (AdmissionPolicy, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "first":
+		return &FirstNPolicy{}, nil
+	case "sample":
+		rate, err := strconv.ParseFloat(arg, 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, errors.New("抽样概率错误: " + arg)
+		}
+		return &SamplingPolicy{rate: rate}, nil
+	case "lottery":
+		window, err := time.ParseDuration(arg)
+		if err != nil || window <= 0 {
+			return nil, errors.New("抽签窗口错误: " + arg)
+		}
+		return NewLotteryPolicy(window), nil
+	}
+	return nil, errors.New("未知的准入策略: " + spec)
+}
+
+// FirstNPolicy 先到先得，前 N 个请求获得资格，N 为库存数量
+type FirstNPolicy struct{}
+
+// Admit 始终放行，由库存数量限制成交
+func (p *FirstNPolicy) Admit(uid int64, stock *ProductStock, campaign *model.Campaign) bool {
+	return true
+}
+
+// SamplingPolicy 按配置的概率随机放行
+type SamplingPolicy struct {
+	rate float64
+}
+
+// Admit 按概率放行
+func (p *SamplingPolicy) Admit(uid int64, stock *ProductStock, campaign *model.Campaign) bool {
+	return rand.Float64() < p.rate
+}
+
+// LotteryPolicy 抽签
+// 窗口从秒杀活动的开始时间算起，没有活动时从策略创建时算起，
+// 窗口期内的请求只登记报名并返回失败，窗口关闭后按剩余库存抽出中签用户，
+// 中签用户再次请求时获得资格，每个用户只能领取一次
+// 报名与中签名单只保存在内存中，进程重启后已报名的用户需要在窗口内重新报名
+type LotteryPolicy struct {
+	// 报名窗口长度
+	window time.Duration
+	// 策略创建时间，没有秒杀活动时作为窗口开始时间
+	createdAt time.Time
+	// 当前抽签所属的秒杀活动，活动变化时重新报名
+	campaignID int64
+	// 报名用户
+	entrants map[int64]struct{}
+	// 中签且尚未领取的用户
+	winners map[int64]struct{}
+	// 是否已抽签
+	drawn bool
+}
+
+// NewLotteryPolicy 创建抽签策略
+func NewLotteryPolicy(window time.Duration) *LotteryPolicy {
+	return &LotteryPolicy{
+		window:    window,
+		createdAt: time.Now(),
+		entrants:  make(map[int64]struct{}),
+		winners:   make(map[int64]struct{}),
+	}
+}
+
+// Admit 窗口期内报名，窗口关闭后中签用户获得资格
+func (p *LotteryPolicy) Admit(uid int64, stock *ProductStock, campaign *model.Campaign) bool {
+	if uid <= 0 {
+		return false
+	}
+	start := p.createdAt
+	var campaignID int64
+	if campaign != nil {
+		start = campaign.StartTime
+		campaignID = campaign.ID
+	}
+	// 新的秒杀活动重新报名抽签
+	if campaignID != p.campaignID {
+		p.campaignID = campaignID
+		p.entrants = make(map[int64]struct{})
+		p.winners = make(map[int64]struct{})
+		p.drawn = false
+	}
+	if time.Now().Before(start.Add(p.window)) {
+		p.entrants[uid] = struct{}{}
+		return false
+	}
+	if !p.drawn {
+		// 中签人数不超过剩余库存，活动有数量限制时不超过活动剩余数量
+		n := stock.productNum - stock.sum
+		if campaign != nil && campaign.Number > 0 {
+			n = min(n, campaign.Number-stock.campaigns[campaignID])
+		}
+		p.draw(n)
+	}
+	if _, ok := p.winners[uid]; !ok {
+		return false
+	}
+	delete(p.winners, uid)
+	return true
+}
+
+// draw 从报名用户中随机抽出 n 个中签用户
+func (p *LotteryPolicy) draw(n int64) {
+	p.drawn = true
+	entrants := make([]int64, 0, len(p.entrants))
+	for uid := range p.entrants {
+		entrants = append(entrants, uid)
+	}
+	rand.Shuffle(len(entrants), func(i, j int) {
+		entrants[i], entrants[j] = entrants[j], entrants[i]
+	})
+	for i := 0; i < len(entrants) && int64(i) < n; i++ {
+		p.winners[entrants[i]] = struct{}{}
+	}
+	p.entrants = nil
+	fmt.Println("抽签完成，中签人数:", len(p.winners))
+}
+
+// saleRetention 售出记录的保留时间
+// 网关只在同一次抢购请求内归还，超过该时间的售出不再接受归还
+const saleRetention = 10 * time.Minute
+
+// sale 可以归还的售出记录
+type sale struct {
+	requestID  string
+	campaignID int64
+	soldAt     time.Time
+}
+
+// ProductStock 单个秒杀商品的数量控制
+type ProductStock struct {
+	// 商品 ID
+	productID int64
+	// 库存日志
+	journal *StockJournal
+	// 已售数量
+	sum int64
+	// 预存商品数量
+	productNum int64
+	// 准入策略
+	policy AdmissionPolicy
+	// 各秒杀活动的已售数量，key 为活动 ID，重置库存时不清零
+	campaigns map[int64]int64
+	// 可以归还的售出记录，key 为请求 ID，只保存在内存中，进程重启前的售出不能归还
+	sales map[string]*sale
+	// 按售出时间排列的售出记录，用于清理过期记录
+	saleOrder []*sale
+	// 互斥锁
+	sync.Mutex
+}
+
+// GetOne 获取一件商品
+// requestID 为订单的请求 ID，归还时据此找到售出记录，
+// campaign 为进行中的秒杀活动，活动数量大于 0 时已售数量不超过活动数量，为 nil 时只受库存限制
+func (s *ProductStock) GetOne(uid int64, requestID string, campaign *model.Campaign) bool {
+	if requestID == "" {
+		return false
+	}
 	// 加锁
-	mutex.Lock()
-	defer mutex.Unlock()
-	count++
+	s.Lock()
+	defer s.Unlock()
+	s.expireSales()
+	// 同一请求已经售出
+	if _, ok := s.sales[requestID]; ok {
+		return true
+	}
 	// 判断数据是否超限
-	if count%100 == 0 {
-		if sum < productNum {
-			sum++
-			fmt.Println(sum)
-			return true
+	if s.sum >= s.productNum {
+		return false
+	}
+	var campaignID int64
+	if campaign != nil {
+		campaignID = campaign.ID
+		if campaign.Number > 0 && s.campaigns[campaignID] >= campaign.Number {
+			return false
+		}
+	}
+	// 由准入策略决定是否获得资格
+	if !s.policy.Admit(uid, s, campaign) {
+		return false
+	}
+	// 先写日志再计数，写入失败则本次不售出
+	err := s.journal.Append(&journalEntry{
+		Op:         journalSell,
+		ProductID:  s.productID,
+		CampaignID: campaignID,
+		RequestID:  requestID,
+	})
+	if err != nil {
+		fmt.Println("写入库存日志失败:", err)
+		return false
+	}
+	s.sum++
+	if campaignID != 0 {
+		s.campaigns[campaignID]++
+	}
+	record := &sale{requestID: requestID, campaignID: campaignID, soldAt: time.Now()}
+	s.sales[requestID] = record
+	s.saleOrder = append(s.saleOrder, record)
+	return true
+}
+
+// ReturnOne 归还请求 ID 对应的一件商品，计入售出时的秒杀活动
+// 网关获取权限后下单失败时调用，避免库存少卖，
+// 没有对应的售出记录或已经归还时返回 false
+func (s *ProductStock) ReturnOne(requestID string) bool {
+	s.Lock()
+	defer s.Unlock()
+	s.expireSales()
+	record, ok := s.sales[requestID]
+	if !ok {
+		return false
+	}
+	err := s.journal.Append(&journalEntry{
+		Op:         journalReturn,
+		ProductID:  s.productID,
+		CampaignID: record.campaignID,
+		RequestID:  requestID,
+	})
+	if err != nil {
+		fmt.Println("写入库存日志失败:", err)
+		return false
+	}
+	delete(s.sales, requestID)
+	if s.sum > 0 {
+		s.sum--
+	}
+	if s.campaigns[record.campaignID] > 0 {
+		s.campaigns[record.campaignID]--
+	}
+	return true
+}
+
+// expireSales 清理超过保留时间的售出记录，调用时已持有锁
+func (s *ProductStock) expireSales() {
+	for len(s.saleOrder) > 0 && time.Since(s.saleOrder[0].soldAt) > saleRetention {
+		record := s.saleOrder[0]
+		s.saleOrder[0] = nil
+		s.saleOrder = s.saleOrder[1:]
+		if s.sales[record.requestID] == record {
+			delete(s.sales, record.requestID)
 		}
 	}
-	return false
+}
+
+// SetPolicy 修改准入策略
+func (s *ProductStock) SetPolicy(spec string) error {
+	policy, err := ParseAdmissionPolicy(spec)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	err = s.journal.Append(&journalEntry{Op: journalPolicy, ProductID: s.productID, Policy: spec})
+	if err != nil {
+		return err
+	}
+	s.policy = policy
+	return nil
+}
+
+// Reset 重置库存，已售数量与计数清零
+func (s *ProductStock) Reset(productNum int64) error {
+	s.Lock()
+	defer s.Unlock()
+	err := s.journal.Append(&journalEntry{Op: journalReset, ProductID: s.productID, ProductNum: productNum})
+	if err != nil {
+		return err
+	}
+	s.productNum = productNum
+	s.sum = 0
+	return nil
+}
+
+// StockControl 多个秒杀商品的数量控制
+type StockControl struct {
+	// key 为商品 ID
+	stocks map[int64]*ProductStock
+	// 库存日志
+	journal *StockJournal
+	sync.RWMutex
+}
+
+// Restore 根据日志回放结果恢复库存，不再写入日志
+func (c *StockControl) Restore(journal *StockJournal, states map[int64]*stockState) {
+	c.Lock()
+	defer c.Unlock()
+	c.journal = journal
+	for productID, state := range states {
+		stock := &ProductStock{
+			productID:  productID,
+			journal:    journal,
+			productNum: state.productNum,
+			sum:        state.sum,
+			campaigns:  state.campaigns,
+			sales:      make(map[string]*sale),
+		}
+		stock.policy = mustPolicy(state.policy)
+		c.stocks[productID] = stock
+	}
+}
+
+// mustPolicy 解析准入策略，配置为空或错误时使用默认策略
+func mustPolicy(spec string) AdmissionPolicy {
+	if spec != "" {
+		policy, err := ParseAdmissionPolicy(spec)
+		if err == nil {
+			return policy
+		}
+		fmt.Println(err)
+	}
+	policy, err := ParseAdmissionPolicy(defaultPolicy)
+	if err != nil {
+		log.Fatal("Err:", err)
+	}
+	return policy
+}
+
+// Get 获取指定商品的库存计数
+func (c *StockControl) Get(productID int64) (*ProductStock, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	stock, ok := c.stocks[productID]
+	return stock, ok
+}
+
+// Set 加载或重置指定商品的库存
+func (c *StockControl) Set(productID, productNum int64) error {
+	c.Lock()
+	stock, ok := c.stocks[productID]
+	if !ok {
+		stock = &ProductStock{
+			productID: productID,
+			journal:   c.journal,
+			campaigns: make(map[int64]int64),
+			sales:     make(map[string]*sale),
+		}
+		stock.policy = mustPolicy("")
+		c.stocks[productID] = stock
+	}
+	c.Unlock()
+	return stock.Reset(productNum)
+}
+
+// Load 根据商品表批量加载库存
+// 日志中已有的商品保留恢复出的已售数量，不会被重置
+func (c *StockControl) Load(products []*model.Product) error {
+	for _, product := range products {
+		if _, ok := c.Get(product.ID); ok {
+			continue
+		}
+		if err := c.Set(product.ID, product.Number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetOneProduct 获取秒杀商品
+func GetOneProduct(uid, productID int64, requestID string, campaign *model.Campaign) bool {
+	stock, ok := stockControl.Get(productID)
+	if !ok {
+		return false
+	}
+	return stock.GetOne(uid, requestID, campaign)
+}
+
+// ReturnOneProduct 归还请求 ID 对应的秒杀商品，计入售出时的活动
+func ReturnOneProduct(productID int64, requestID string) bool {
+	stock, ok := stockControl.Get(productID)
+	if !ok {
+		return false
+	}
+	return stock.ReturnOne(requestID)
 }
 
 // GetProduct 获取产品
+// 由网关以集群密钥签名调用
 func GetProduct(w http.ResponseWriter, req *http.Request) {
-	if GetOneProduct() {
+	productID, err := strconv.ParseInt(req.URL.Query().Get("productID"), 10, 64)
+	if err != nil {
+		w.Write([]byte("false"))
+		return
+	}
+	// 校验秒杀活动时间，活动时间外返回具体原因
+	campaign, err := campaignService.CheckCampaign(productID, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// 用户 ID 由网关在签名的查询参数中传递，抽签策略依赖该值
+	uid, _ := strconv.ParseInt(req.URL.Query().Get("uid"), 10, 64)
+	if GetOneProduct(uid, productID, req.URL.Query().Get("requestID"), campaign) {
 		w.Write([]byte("true"))
 		return
 	}
@@ -45,9 +640,173 @@ func GetProduct(w http.ResponseWriter, req *http.Request) {
 	return
 }
 
+// RPCGetOne 内部协议获取数量控制权限
+func RPCGetOne(ctx context.Context, msg *rpc.Message) rpc.Code {
+	// 没有请求 ID 的售出无法归还
+	if msg.RequestID == (rpc.RequestID{}) {
+		return rpc.CodeError
+	}
+	// 校验秒杀活动时间，活动时间外返回具体原因
+	campaign, err := campaignService.CheckCampaign(msg.ProductID, time.Now())
+	if err != nil {
+		return rpc.CampaignCode(err)
+	}
+	if GetOneProduct(msg.UserID, msg.ProductID, msg.RequestID.String(), campaign) {
+		return rpc.CodeOK
+	}
+	return rpc.CodeDenied
+}
+
+// ReleaseProduct 归还产品
+// 由网关以集群密钥签名调用
+func ReleaseProduct(w http.ResponseWriter, req *http.Request) {
+	productID, err := strconv.ParseInt(req.URL.Query().Get("productID"), 10, 64)
+	if err != nil {
+		w.Write([]byte("false"))
+		return
+	}
+	if !ReturnOneProduct(productID, req.URL.Query().Get("requestID")) {
+		w.Write([]byte("false"))
+		return
+	}
+	w.Write([]byte("true"))
+}
+
+// RPCReleaseOne 内部协议归还数量控制权限
+func RPCReleaseOne(ctx context.Context, msg *rpc.Message) rpc.Code {
+	if !ReturnOneProduct(msg.ProductID, msg.RequestID.String()) {
+		return rpc.CodeDenied
+	}
+	return rpc.CodeOK
+}
+
+// SetStock 加载或重置商品库存
+// 传入 productNum 时按指定数量重置，否则从商品表重新加载
+// 该接口仅供内网管理使用
+func SetStock(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	productID, err := strconv.ParseInt(query.Get("productID"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("商品 ID 错误"))
+		return
+	}
+
+	var productNum int64
+	if numString := query.Get("productNum"); numString != "" {
+		productNum, err = strconv.ParseInt(numString, 10, 64)
+		if err != nil || productNum < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("商品数量错误"))
+			return
+		}
+	} else {
+		product, err := productService.GetProductByID(productID)
+		if err != nil || product.ID == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("商品不存在"))
+			return
+		}
+		productNum = product.Number
+	}
+
+	if err := stockControl.Set(productID, productNum); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("写入库存日志失败"))
+		return
+	}
+	fmt.Println("重置商品库存:", productID, productNum)
+	w.Write([]byte("true"))
+}
+
+// SetPolicy 修改商品的准入策略
+// 该接口仅供内网管理使用
+func SetPolicy(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	productID, err := strconv.ParseInt(query.Get("productID"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("商品 ID 错误"))
+		return
+	}
+	stock, ok := stockControl.Get(productID)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("商品库存未加载"))
+		return
+	}
+	if err := stock.SetPolicy(query.Get("policy")); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	fmt.Println("修改准入策略:", productID, query.Get("policy"))
+	w.Write([]byte("true"))
+}
+
 func main() {
-	http.HandleFunc("/getOne", GetProduct)
-	err := http.ListenAndServe(":8084", nil)
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal("Err:", err)
+	}
+	// 网关的调用使用集群密钥签名
+	if err = cfg.CheckCluster(); err != nil {
+		log.Fatal("Err:", err)
+	}
+	common.SetMySQLDSN(cfg.MySQL.DSN)
+	if _, err = ParseAdmissionPolicy(cfg.GetOne.Policy); err != nil {
+		log.Fatal("Err:", err)
+	}
+	defaultPolicy = cfg.GetOne.Policy
+
+	// 连接数据库
+	db, err := common.NewMySQLConn()
+	if err != nil {
+		log.Fatal("Err:", err)
+	}
+	product := repository.NewProductManager("product", db)
+	productService = service.NewProductService(product)
+	campaign := repository.NewCampaignManager("campaign", db)
+	campaignService = service.NewCampaignServiceWithRequired(campaign, cfg.Campaign.Required)
+
+	// 回放库存日志，恢复重启前的已售数量
+	journal, states, err := OpenStockJournal(cfg.GetOne.Journal)
+	if err != nil {
+		log.Fatal("Err:", err)
+	}
+	defer journal.Close()
+	stockControl.Restore(journal, states)
+
+	// 从商品表加载日志中尚未记录的商品库存
+	products, err := productService.GetAllProduct()
+	if err != nil {
+		fmt.Println("加载商品库存失败:", err)
+	}
+	if err = stockControl.Load(products); err != nil {
+		log.Fatal("Err:", err)
+	}
+
+	// 网关通过内部协议调用，/getOne 保留给使用 HTTP 的网关
+	rpcServer := rpc.NewServer([]byte(cfg.Cluster.Secret))
+	rpcServer.Handle(rpc.OpGetOne, RPCGetOne)
+	rpcServer.Handle(rpc.OpReleaseOne, RPCReleaseOne)
+	go func() {
+		if err := rpcServer.ListenAndServe(cfg.GetOne.RPCAddr); err != nil {
+			log.Fatal("Err:", err)
+		}
+	}()
+
+	// 获取和归还会修改库存，只接受网关以集群密钥签名的请求
+	filter := common.NewFilter()
+	peerAuth := cluster.NewRequestVerifier([]byte(cfg.Cluster.Secret))
+	filter.RegisterFilterURI("/getOne", peerAuth.Filter)
+	filter.RegisterFilterURI("/releaseOne", peerAuth.Filter)
+	http.HandleFunc("/getOne", filter.Handle(GetProduct))
+	http.HandleFunc("/releaseOne", filter.Handle(ReleaseProduct))
+	http.HandleFunc("/setStock", SetStock)
+	http.HandleFunc("/setPolicy", SetPolicy)
+	err = http.ListenAndServe(cfg.GetOne.Addr, nil)
 	if err != nil {
 		log.Fatal("Err:", err)
 	}
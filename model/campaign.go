@@ -0,0 +1,31 @@
+package model
+
+import "time"
+
+// Campaign 秒杀活动模型定义
+type Campaign struct {
+	ID        int64     `json:"campaign_id" sql:"campaign_id" imooc:"campaign_id"`
+	ProductID int64     `json:"product_id" sql:"product_id" imooc:"product_id"`
+	Price     float64   `json:"campaign_price" sql:"campaign_price" imooc:"campaign_price"`
+	Number    int64     `json:"campaign_number" sql:"campaign_number" imooc:"campaign_number"`
+	StartTime time.Time `json:"start_time" sql:"start_time" imooc:"start_time"`
+	EndTime   time.Time `json:"end_time" sql:"end_time" imooc:"end_time"`
+	UserLimit int64     `json:"user_limit" sql:"user_limit" imooc:"user_limit"`
+}
+
+const (
+	CampaignPending = iota // CampaignPending 未开始
+	CampaignActive         // CampaignActive 进行中
+	CampaignEnded          // CampaignEnded 已结束
+)
+
+// Status 判断指定时间活动所处的状态
+func (c *Campaign) Status(now time.Time) int {
+	if now.Before(c.StartTime) {
+		return CampaignPending
+	}
+	if now.Before(c.EndTime) {
+		return CampaignActive
+	}
+	return CampaignEnded
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+const (
+	// OrderPlaced 秒杀成功，订单消息已入队，订单尚未写入数据库
+	OrderPlaced = "order.placed"
+	// OrderCreated 订单已写入数据库，通过 outbox 发布
+	OrderCreated = "order.created"
+)
+
+// OrderEvent 订单事件，广播给库存、通知、统计等下游系统
+// 事件类型同时作为主题模式的路由键
+type OrderEvent struct {
+	Type string `json:"type"`
+	// 订单 ID，订单写入数据库后才有
+	OrderID   int64     `json:"order_id,omitempty"`
+	RequestID string    `json:"request_id"`
+	UserID    int64     `json:"user_id"`
+	ProductID int64     `json:"product_id"`
+	Time      time.Time `json:"time"`
+}
+
+// NewOrderEvent 根据订单消息创建事件
+func NewOrderEvent(eventType string, message *Message) *OrderEvent {
+	return &OrderEvent{
+		Type:      eventType,
+		RequestID: message.RequestID,
+		UserID:    message.UserID,
+		ProductID: message.ProductID,
+		Time:      time.Now(),
+	}
+}
+
+// NewOrderCreatedEvent 根据写入数据库的订单创建事件
+func NewOrderCreatedEvent(order *Order) *OrderEvent {
+	return &OrderEvent{
+		Type:      OrderCreated,
+		OrderID:   order.ID,
+		RequestID: order.RequestID,
+		UserID:    order.UserID,
+		ProductID: order.ProductID,
+		Time:      time.Now(),
+	}
+}
@@ -4,12 +4,15 @@ package model
 type Message struct {
 	ProductID int64
 	UserID    int64
+	// 秒杀请求 ID，消息重复投递时用于去重
+	RequestID string
 }
 
 // NewMessage 创建结构体
-func NewMessage(userID int64, productID int64) *Message {
+func NewMessage(userID int64, productID int64, requestID string) *Message {
 	return &Message{
 		UserID:    userID,
 		ProductID: productID,
+		RequestID: requestID,
 	}
 }
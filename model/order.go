@@ -6,6 +6,8 @@ type Order struct {
 	UserID    int64 `json:"user_id" sql:"user_id" imooc:"user_id"`
 	ProductID int64 `json:"product_id" sql:"product_id" imooc:"product_id"`
 	Status    int   `json:"order_status" sql:"order_status" imooc:"order_status"`
+	// 秒杀请求 ID，同一请求只创建一个订单
+	RequestID string `json:"request_id" sql:"request_id" imooc:"request_id"`
 }
 
 const (
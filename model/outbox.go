@@ -0,0 +1,16 @@
+package model
+
+// Outbox 待发布的事件，与业务数据在同一事务中写入
+type Outbox struct {
+	ID int64 `json:"outbox_id" sql:"outbox_id" imooc:"outbox_id"`
+	// 事件类型，发布时作为路由键
+	EventType string `json:"event_type" sql:"event_type" imooc:"event_type"`
+	// 事件内容，JSON 格式
+	Payload string `json:"payload" sql:"payload" imooc:"payload"`
+	Status  int    `json:"status" sql:"status" imooc:"status"`
+}
+
+const (
+	OutboxPending = iota // OutboxPending 待发布
+	OutboxSent           // OutboxSent 已发布
+)
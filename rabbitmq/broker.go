@@ -0,0 +1,64 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+)
+
+// Publisher 消息发布者
+type Publisher interface {
+	// Publish 发布消息，返回 nil 表示消息已入队，
+	// 失败时由 NotEnqueued 判断消息是否确定没有入队
+	Publish(body []byte) error
+	// Connected 判断当前是否可以发布
+	Connected() bool
+}
+
+// NotEnqueued 判断发布失败时消息是否确定没有入队
+// 正在重连、发送失败或被服务端拒绝时消息没有入队；
+// 等待确认超时或等待期间连接断开时消息可能已经入队，返回 false
+func NotEnqueued(err error) bool {
+	return errors.Is(err, ErrNotConnected) || errors.Is(err, ErrNotSent) || errors.Is(err, ErrNack)
+}
+
+// Consumer 消息消费者
+type Consumer interface {
+	// Consume 订阅队列，最多同时持有 prefetch 条未应答的消息。
+	// ctx 结束或连接断开时关闭返回的 channel，已推送的消息仍可取出，
+	// 连接断开时未应答的消息会重新投递，需要再次调用 Consume
+	Consume(ctx context.Context, prefetch int) (<-chan Delivery, error)
+}
+
+// Delivery 收到的消息，处理后必须调用 Ack、Nack 或 Retry 之一
+type Delivery struct {
+	Body    []byte
+	Headers map[string]interface{}
+	// 发布时的路由键，主题模式下用于区分消息类型
+	RoutingKey string
+	// 是否为重新投递的消息，之前可能已被处理过
+	Redelivered bool
+
+	acknowledger acknowledger
+}
+
+// acknowledger 由消息队列实现的应答方式
+type acknowledger interface {
+	ack() error
+	nack(requeue bool) error
+	retry(headers map[string]interface{}) error
+}
+
+// Ack 确认消息已处理
+func (d Delivery) Ack() error {
+	return d.acknowledger.ack()
+}
+
+// Nack 拒绝消息，requeue 为 true 时放回原队列，否则转入死信队列
+func (d Delivery) Nack(requeue bool) error {
+	return d.acknowledger.nack(requeue)
+}
+
+// Retry 使用新的消息头将消息重新发布到所在队列末尾，并确认当前消息
+func (d Delivery) Retry(headers map[string]interface{}) error {
+	return d.acknowledger.retry(headers)
+}
@@ -0,0 +1,65 @@
+package rabbitmq
+
+import (
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// confirmer 分发一个 channel 的发布确认
+// 由一个协程读取 NotifyPublish，按投递标签通知等待的发布者，
+// 发布者不需要持有锁等待确认，多条消息可以同时等待
+type confirmer struct {
+	// 投递标签对应的等待者，收到确认后写入是否确认成功
+	pending map[uint64]chan bool
+	// channel 已关闭
+	closed bool
+	sync.Mutex
+}
+
+// newConfirmer 创建并开始读取 confirms，confirms 关闭时通知所有等待者
+func newConfirmer(confirms <-chan amqp.Confirmation) *confirmer {
+	c := &confirmer{pending: make(map[uint64]chan bool)}
+	go c.run(confirms)
+	return c
+}
+
+// run 读取发布确认，超时后不再等待的确认直接丢弃
+func (c *confirmer) run(confirms <-chan amqp.Confirmation) {
+	for confirm := range confirms {
+		c.Lock()
+		if wait, ok := c.pending[confirm.DeliveryTag]; ok {
+			delete(c.pending, confirm.DeliveryTag)
+			wait <- confirm.Ack
+		}
+		c.Unlock()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.closed = true
+	for tag, wait := range c.pending {
+		delete(c.pending, tag)
+		close(wait)
+	}
+}
+
+// add 在发布前登记投递标签，避免确认先于登记到达
+func (c *confirmer) add(tag uint64) <-chan bool {
+	wait := make(chan bool, 1)
+	c.Lock()
+	defer c.Unlock()
+	if c.closed {
+		close(wait)
+	} else {
+		c.pending[tag] = wait
+	}
+	return wait
+}
+
+// remove 发布失败或等待超时后取消登记
+func (c *confirmer) remove(tag uint64) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.pending, tag)
+}
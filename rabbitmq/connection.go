@@ -0,0 +1,159 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// 重连间隔，每次失败后翻倍直到上限
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// State 连接状态
+type State int32
+
+const (
+	// StateConnecting 正在连接或重连
+	StateConnecting State = iota
+	// StateConnected 已连接，可以发布和消费
+	StateConnected
+	// StateClosed 已调用 Destroy
+	StateClosed
+)
+
+// String 状态名称
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// State 获取当前连接状态
+func (r *RabbitMQ) State() State {
+	return State(r.state.Load())
+}
+
+// Connected 判断是否已连接
+func (r *RabbitMQ) Connected() bool {
+	return r.State() == StateConnected
+}
+
+// keepAlive 建立连接并在连接或 channel 断开后按指数退避重连，直到 Destroy
+func (r *RabbitMQ) keepAlive() {
+	delay := minReconnectDelay
+	for {
+		connClosed, channelClosed, err := r.connect()
+		if err != nil {
+			fmt.Printf("连接 rabbitmq 失败: %s，%s 后重试\n", err, delay)
+			select {
+			case <-r.done:
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+		delay = minReconnectDelay
+
+		// 等待连接或 channel 断开
+		select {
+		case <-r.done:
+			return
+		case err = <-connClosed:
+		case err = <-channelClosed:
+		}
+		r.disconnect()
+		fmt.Println("rabbitmq 连接断开，开始重连:", err)
+	}
+}
+
+// connect 建立连接和 channel，开启发布确认并申请拓扑
+func (r *RabbitMQ) connect() (chan *amqp.Error, chan *amqp.Error, error) {
+	conn, err := amqp.Dial(r.MQUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// 开启发布确认，消息写入持久化队列后服务端才会确认
+	if err = channel.Confirm(false); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	// 重连后重新申请队列和交换机
+	if r.topology != nil {
+		if err = r.topology(channel); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	r.Lock()
+	defer r.Unlock()
+	if r.State() == StateClosed {
+		conn.Close()
+		return nil, nil, ErrClosed
+	}
+	r.conn = conn
+	r.channel = channel
+	r.confirmer = newConfirmer(channel.NotifyPublish(make(chan amqp.Confirmation, 16)))
+	// 新 channel 的投递标签从 1 开始
+	r.published = 0
+	r.state.Store(int32(StateConnected))
+	close(r.ready)
+	fmt.Println("rabbitmq 已连接:", r.QueueName)
+	return connClosed, channelClosed, nil
+}
+
+// disconnect 关闭断开的连接，等待重连
+func (r *RabbitMQ) disconnect() {
+	r.Lock()
+	defer r.Unlock()
+	if r.State() == StateClosed {
+		return
+	}
+	r.state.Store(int32(StateConnecting))
+	r.ready = make(chan struct{})
+	r.conn.Close()
+}
+
+// waitChannel 等待连接可用，返回当前 channel，ctx 结束时返回 ctx 的错误
+func (r *RabbitMQ) waitChannel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		r.Lock()
+		channel, ready, state := r.channel, r.ready, r.State()
+		r.Unlock()
+		switch state {
+		case StateClosed:
+			return nil, ErrClosed
+		case StateConnected:
+			return channel, nil
+		}
+		select {
+		case <-ready:
+		case <-r.done:
+			return nil, ErrClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"litemall/model"
+	"litemall/repository"
+	"litemall/service"
+)
+
+const (
+	// DefaultConcurrency 默认并发处理消息的协程数
+	DefaultConcurrency = 8
+	// DefaultPrefetch 默认最多推送的未应答消息数
+	DefaultPrefetch = 32
+	// DefaultBatchSize 默认每个事务合并的订单数，逐条处理
+	DefaultBatchSize = 1
+	// DefaultBatchWait 默认凑批等待时间
+	DefaultBatchWait = 20 * time.Millisecond
+)
+
+// OrderConsumer 订单消费者，从消息队列接收秒杀消息并创建订单
+type OrderConsumer struct {
+	consumer     Consumer
+	orderService service.IOrderService
+	// 消费失败时最多重试次数，超过后转入死信队列
+	MaxRetries int
+	// 重试前等待时间，随重试次数递增
+	RetryDelay time.Duration
+	// 并发处理消息的协程数
+	Concurrency int
+	// 最多持有的未应答消息数，需不小于 Concurrency * BatchSize 才能凑满批次
+	Prefetch int
+	// 每个事务最多合并的订单数，为 1 时逐条处理
+	BatchSize int
+	// 凑批等待时间，超时后处理已收到的消息
+	BatchWait time.Duration
+}
+
+// NewOrderConsumer 创建订单消费者
+func NewOrderConsumer(consumer Consumer, orderService service.IOrderService) *OrderConsumer {
+	return &OrderConsumer{
+		consumer:     consumer,
+		orderService: orderService,
+		MaxRetries:   DefaultMaxRetries,
+		RetryDelay:   DefaultRetryDelay,
+		Concurrency:  DefaultConcurrency,
+		Prefetch:     DefaultPrefetch,
+		BatchSize:    DefaultBatchSize,
+		BatchWait:    DefaultBatchWait,
+	}
+}
+
+// Run 由 Concurrency 个协程并发处理消息，连接断开后等待重连并继续消费，
+// ctx 结束后停止接收新消息，处理并应答已收到的消息后返回
+func (c *OrderConsumer) Run(ctx context.Context) {
+	log.Println("[*] Waiting for messages, To exit press CTRL+C")
+	for {
+		deliveries, err := c.consumer.Consume(ctx, c.Prefetch)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, ErrClosed) {
+				return
+			}
+			// channel 异常时等待重连
+			fmt.Println(err)
+			time.Sleep(minReconnectDelay)
+			continue
+		}
+
+		// 处理消息，停止消费或连接断开时 deliveries 关闭
+		var wg sync.WaitGroup
+		for i := 0; i < c.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.work(deliveries)
+			}()
+		}
+		wg.Wait()
+
+		if ctx.Err() != nil {
+			fmt.Println("消费已停止，已收到的消息处理完成")
+			return
+		}
+		fmt.Println("消费中断，等待重连")
+	}
+}
+
+// work 从 deliveries 中取出消息，凑满 BatchSize 条或等待 BatchWait 后处理，
+// deliveries 关闭时处理剩余消息后返回
+func (c *OrderConsumer) work(deliveries <-chan Delivery) {
+	batch := make([]Delivery, 0, c.BatchSize)
+	// 批次中第一条消息到达后开始计时
+	var flush <-chan time.Time
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				c.handleBatch(batch)
+				return
+			}
+			log.Printf("Received a message: %s", d.Body)
+			batch = append(batch, d)
+			if len(batch) == 1 {
+				flush = time.After(c.BatchWait)
+			}
+			if len(batch) < c.BatchSize {
+				continue
+			}
+		case <-flush:
+		}
+		c.handleBatch(batch)
+		batch = batch[:0]
+		flush = nil
+	}
+}
+
+// handleBatch 在同一事务中处理一批订单消息并逐条应答
+// 整批失败时逐条重新处理，避免一条消息失败导致整批重试
+func (c *OrderConsumer) handleBatch(batch []Delivery) {
+	deliveries := make([]Delivery, 0, len(batch))
+	messages := make([]*model.Message, 0, len(batch))
+	for _, d := range batch {
+		message, err := parseOrder(d.Body)
+		if err != nil {
+			c.settle(d, err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+		messages = append(messages, message)
+	}
+
+	switch len(messages) {
+	case 0:
+		return
+	case 1:
+		_, err := c.orderService.InsertOrderByMessage(messages[0])
+		c.settle(deliveries[0], err)
+		return
+	}
+
+	if _, err := c.orderService.InsertOrdersByMessage(messages); err != nil {
+		fmt.Printf("批量处理 %d 条消息失败，改为逐条处理: %s\n", len(messages), err)
+		for i, message := range messages {
+			_, err := c.orderService.InsertOrderByMessage(message)
+			c.settle(deliveries[i], err)
+		}
+		return
+	}
+	for _, d := range deliveries {
+		c.settle(d, nil)
+	}
+}
+
+// settle 根据处理结果应答消息
+// 处理失败时带上重试次数重新发布到队列末尾，超过最多重试次数或无法处理的消息转入死信队列
+func (c *OrderConsumer) settle(d Delivery, err error) {
+	if err == nil {
+		d.Ack()
+		return
+	}
+
+	retries := RetryCount(d.Headers)
+	var permanent *permanentError
+	// 库存不足时重试也无法创建订单
+	if errors.As(err, &permanent) || errors.Is(err, repository.ErrSoldOut) || retries >= c.MaxRetries {
+		fmt.Printf("消息处理失败 %d 次，转入死信队列: %s\n", retries+1, err)
+		d.Nack(false)
+		return
+	}
+
+	fmt.Printf("消息处理失败，第 %d 次重试: %s\n", retries+1, err)
+	time.Sleep(c.RetryDelay * time.Duration(retries+1))
+	headers := make(map[string]interface{}, len(d.Headers)+1)
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[RetryHeader] = int32(retries + 1)
+	if err = d.Retry(headers); err != nil {
+		// 重新发布失败时放回原队列，重试次数不变
+		d.Nack(true)
+	}
+}
+
+// parseOrder 解析订单消息
+func parseOrder(body []byte) (*model.Message, error) {
+	message := &model.Message{}
+	if err := json.Unmarshal(body, message); err != nil {
+		// 消息格式错误，重试也无法处理
+		return nil, &permanentError{err}
+	}
+	return message, nil
+}
@@ -0,0 +1,198 @@
+package rabbitmq
+
+import (
+	"context"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// RetryHeader 记录消息重试次数的消息头
+	RetryHeader = "x-retry-count"
+	// DefaultMaxRetries 默认最多重试次数，超过后转入死信队列
+	DefaultMaxRetries = 3
+	// DefaultRetryDelay 默认重试前等待时间，随重试次数递增
+	DefaultRetryDelay = time.Second
+)
+
+// permanentError 重试也无法处理的错误，消息直接转入死信队列
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// DeadLetterExchange 队列对应的死信交换机名称
+func DeadLetterExchange(queueName string) string {
+	return queueName + ".dlx"
+}
+
+// DeadLetterQueue 队列对应的死信队列名称
+func DeadLetterQueue(queueName string) string {
+	return queueName + ".dead"
+}
+
+// declareDeadLetter 申请死信交换机和死信队列
+func declareDeadLetter(channel *amqp.Channel, queueName string) error {
+	exchange := DeadLetterExchange(queueName)
+	err := channel.ExchangeDeclare(
+		exchange,
+		"direct", // 交换机类型
+		true,     // 是否持久化
+		false,    // 是否为自动删除
+		false,    // true 表示这个 exchange 不可以被 client 用来推送消息，仅用来进行 exchange 和 exchange 之间的绑定
+		false,    // 是否阻塞
+		nil,      // 额外属性
+	)
+	if err != nil {
+		return err
+	}
+	_, err = channel.QueueDeclare(
+		DeadLetterQueue(queueName),
+		true,  // 是否持久化
+		false, // 是否为自动删除
+		false, // 是否具有排他性
+		false, // 是否阻塞
+		nil,   // 额外属性
+	)
+	if err != nil {
+		return err
+	}
+	return channel.QueueBind(DeadLetterQueue(queueName), queueName, exchange, false, nil)
+}
+
+// RetryCount 获取消息已重试次数
+func RetryCount(headers amqp.Table) int {
+	switch v := headers[RetryHeader].(type) {
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// DeadLetter 死信队列中的消息
+type DeadLetter struct {
+	Body []byte
+	// 转入死信队列前的重试次数
+	Retries int
+	// 转入死信队列的原因，例如 rejected
+	Reason string
+	// 转入死信队列的时间
+	Time time.Time
+}
+
+// WaitConnected 等待连接可用
+func (r *RabbitMQ) WaitConnected(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		r.Lock()
+		ready, state := r.ready, r.State()
+		r.Unlock()
+		switch state {
+		case StateClosed:
+			return ErrClosed
+		case StateConnected:
+			return nil
+		}
+		select {
+		case <-ready:
+		case <-r.done:
+			return ErrClosed
+		case <-timer.C:
+			return ErrNotConnected
+		}
+	}
+}
+
+// InspectDeadLetters 查看死信队列中最早的 limit 条消息，消息仍保留在队列中
+func (r *RabbitMQ) InspectDeadLetters(limit int) ([]*DeadLetter, error) {
+	channel, err := r.waitChannel(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []*DeadLetter
+	var last uint64
+	for len(letters) < limit {
+		d, ok, err := channel.Get(DeadLetterQueue(r.QueueName), false)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, newDeadLetter(d))
+		last = d.DeliveryTag
+	}
+	// 全部放回死信队列
+	if last > 0 {
+		if err = channel.Nack(last, true, true); err != nil {
+			return nil, err
+		}
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters 将死信队列中最早的 n 条消息重新发布到原队列，重试次数清零
+// 返回重新发布的消息数
+func (r *RabbitMQ) ReplayDeadLetters(n int) (int, error) {
+	channel, err := r.waitChannel(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for replayed < n {
+		d, ok, err := channel.Get(DeadLetterQueue(r.QueueName), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+		err = r.publish("", r.QueueName, amqp.Publishing{
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			Body:         d.Body,
+		})
+		if err != nil {
+			d.Nack(false, true)
+			return replayed, err
+		}
+		d.Ack(false)
+		replayed++
+	}
+	return replayed, nil
+}
+
+// newDeadLetter 解析死信消息
+func newDeadLetter(d amqp.Delivery) *DeadLetter {
+	letter := &DeadLetter{
+		Body:    d.Body,
+		Retries: RetryCount(d.Headers),
+	}
+	// rabbitmq 在 x-death 中记录死信原因，最近一次在最前
+	if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			letter.Reason, _ = death["reason"].(string)
+			letter.Time, _ = death["time"].(time.Time)
+		}
+	}
+	return letter
+}
@@ -0,0 +1,67 @@
+package rabbitmq
+
+import "github.com/streadway/amqp"
+
+// 交换机类型
+const (
+	// KindFanout 订阅模式，消息广播到所有绑定的队列
+	KindFanout = "fanout"
+	// KindDirect 路由模式，消息投递到绑定键与路由键相同的队列
+	KindDirect = "direct"
+	// KindTopic 主题模式，绑定键可以使用通配符，* 匹配一个单词，# 匹配零个或多个单词
+	KindTopic = "topic"
+)
+
+// NewRabbitMQPubSub 创建订阅模式实例
+// queueName 为空时只发布，不为空时申请持久化队列并绑定到交换机，用于消费
+func NewRabbitMQPubSub(exchangeName, queueName string) *RabbitMQ {
+	return newRabbitMQExchange(KindFanout, exchangeName, "", queueName)
+}
+
+// NewRabbitMQRouting 创建路由模式实例
+// 发布时使用 routingKey 作为路由键，消费时以 routingKey 为绑定键将 queueName 队列绑定到交换机
+func NewRabbitMQRouting(exchangeName, routingKey, queueName string) *RabbitMQ {
+	return newRabbitMQExchange(KindDirect, exchangeName, routingKey, queueName)
+}
+
+// NewRabbitMQTopic 创建主题模式实例
+// 发布时 routingKey 为以 . 分隔的单词，例如 order.placed，
+// 消费时 routingKey 为绑定键，例如 order.# 接收所有订单事件
+func NewRabbitMQTopic(exchangeName, routingKey, queueName string) *RabbitMQ {
+	return newRabbitMQExchange(KindTopic, exchangeName, routingKey, queueName)
+}
+
+// newRabbitMQExchange 创建使用交换机的实例
+// 在后台连接 rabbitmq，断开后自动重连，连接状态通过 State 获取
+func newRabbitMQExchange(kind, exchangeName, key, queueName string) *RabbitMQ {
+	rabbitmq := NewRabbitMQ(queueName, exchangeName, key)
+	rabbitmq.topology = func(channel *amqp.Channel) error {
+		return rabbitmq.declareExchange(channel, kind)
+	}
+	go rabbitmq.keepAlive()
+	return rabbitmq
+}
+
+// declareExchange 申请交换机，有队列名称时申请队列并绑定
+// 交换机和队列均持久化，拒绝的消息转入死信队列
+func (r *RabbitMQ) declareExchange(channel *amqp.Channel, kind string) error {
+	err := channel.ExchangeDeclare(
+		r.Exchange,
+		kind,  // 交换机类型
+		true,  // 是否持久化
+		false, // 是否为自动删除
+		false, // true 表示这个 exchange 不可以被 client 用来推送消息，仅用来进行 exchange 和 exchange 之间的绑定
+		false, // 是否阻塞
+		nil,   // 额外属性
+	)
+	if err != nil || r.QueueName == "" {
+		return err
+	}
+
+	// 队列与简单模式相同
+	if err = r.declareSimple(channel); err != nil {
+		return err
+	}
+	// 订阅模式忽略绑定键
+	return channel.QueueBind(r.QueueName, r.Key, r.Exchange, false, nil)
+}
@@ -0,0 +1,254 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrStaleDelivery 消息已应答，或连接断开后已重新投递
+var ErrStaleDelivery = errors.New("消息已应答或已重新投递")
+
+// memoryMessage 内存队列中的消息
+type memoryMessage struct {
+	body        []byte
+	headers     map[string]interface{}
+	redelivered bool
+	// 持有该消息的消费者
+	consumer *memoryConsumer
+}
+
+// memoryConsumer 内存队列的消费者
+type memoryConsumer struct {
+	// 未应答的消息数
+	unacked int
+}
+
+// MemoryBroker 进程内的消息队列，不依赖 rabbitmq，用于测试
+// 与 rabbitmq 一样按 prefetch 限制未应答消息数，拒绝的消息放回队列或转入死信，
+// Disconnect 模拟连接断开，未应答的消息重新投递
+type MemoryBroker struct {
+	// 待投递的消息
+	queue []*memoryMessage
+	// 已投递未应答的消息，按投递标签索引
+	unacked map[uint64]*memoryMessage
+	// 最近一条消息的投递标签
+	tag uint64
+	// 死信
+	dead []*DeadLetter
+	// 断开次数，断开前投递的消息不能再应答
+	generation int
+	// 队列变化时关闭并重新创建，唤醒等待的消费者
+	changed chan struct{}
+	closed  bool
+	sync.Mutex
+}
+
+// NewMemoryBroker 创建内存消息队列
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		unacked: make(map[uint64]*memoryMessage),
+		changed: make(chan struct{}),
+	}
+}
+
+// Publish 发布消息到队列末尾，已销毁时消息没有入队
+func (m *MemoryBroker) Publish(body []byte) error {
+	if err := m.push(&memoryMessage{body: body}); err != nil {
+		return fmt.Errorf("%w: %w", ErrNotSent, err)
+	}
+	return nil
+}
+
+// Connected 判断是否可以发布
+func (m *MemoryBroker) Connected() bool {
+	m.Lock()
+	defer m.Unlock()
+	return !m.closed
+}
+
+// Consume 订阅队列，ctx 结束、Disconnect 或 Destroy 时关闭返回的 channel
+func (m *MemoryBroker) Consume(ctx context.Context, prefetch int) (<-chan Delivery, error) {
+	m.Lock()
+	if m.closed {
+		m.Unlock()
+		return nil, ErrClosed
+	}
+	generation := m.generation
+	m.Unlock()
+
+	consumer := &memoryConsumer{}
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			m.Lock()
+			if m.closed || m.generation != generation {
+				m.Unlock()
+				return
+			}
+			if consumer.unacked >= prefetch || len(m.queue) == 0 {
+				changed := m.changed
+				m.Unlock()
+				select {
+				case <-changed:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			msg := m.queue[0]
+			m.queue = m.queue[1:]
+			m.tag++
+			tag := m.tag
+			msg.consumer = consumer
+			consumer.unacked++
+			m.unacked[tag] = msg
+			m.Unlock()
+
+			d := Delivery{
+				Body:         msg.body,
+				Headers:      msg.headers,
+				Redelivered:  msg.redelivered,
+				acknowledger: &memoryAcknowledger{broker: m, tag: tag},
+			}
+			select {
+			case deliveries <- d:
+			case <-ctx.Done():
+				// 没有投递出去，放回队首
+				m.Lock()
+				if m.remove(tag) != nil {
+					m.queue = append([]*memoryMessage{msg}, m.queue...)
+					m.notify()
+				}
+				m.Unlock()
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// Disconnect 模拟连接断开，关闭所有消费者的 channel，未应答的消息放回队首并标记为重新投递
+func (m *MemoryBroker) Disconnect() {
+	m.Lock()
+	defer m.Unlock()
+	m.generation++
+	// 按投递顺序放回
+	tags := make([]uint64, 0, len(m.unacked))
+	for tag := range m.unacked {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	requeued := make([]*memoryMessage, 0, len(tags))
+	for _, tag := range tags {
+		msg := m.remove(tag)
+		msg.redelivered = true
+		requeued = append(requeued, msg)
+	}
+	m.queue = append(requeued, m.queue...)
+	m.notify()
+}
+
+// Destroy 关闭队列，消费者的 channel 随之关闭
+func (m *MemoryBroker) Destroy() {
+	m.Lock()
+	defer m.Unlock()
+	m.closed = true
+	m.notify()
+}
+
+// Len 待投递和未应答的消息数
+func (m *MemoryBroker) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.queue) + len(m.unacked)
+}
+
+// DeadLetters 转入死信的消息
+func (m *MemoryBroker) DeadLetters() []*DeadLetter {
+	m.Lock()
+	defer m.Unlock()
+	return append([]*DeadLetter(nil), m.dead...)
+}
+
+// push 添加消息到队列末尾
+func (m *MemoryBroker) push(msg *memoryMessage) error {
+	m.Lock()
+	defer m.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.queue = append(m.queue, msg)
+	m.notify()
+	return nil
+}
+
+// remove 移除未应答的消息，已移除时返回 nil
+func (m *MemoryBroker) remove(tag uint64) *memoryMessage {
+	msg, ok := m.unacked[tag]
+	if !ok {
+		return nil
+	}
+	delete(m.unacked, tag)
+	msg.consumer.unacked--
+	msg.consumer = nil
+	return msg
+}
+
+// notify 唤醒等待的消费者
+func (m *MemoryBroker) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// memoryAcknowledger 应答内存队列中的消息
+type memoryAcknowledger struct {
+	broker *MemoryBroker
+	tag    uint64
+}
+
+func (a *memoryAcknowledger) ack() error {
+	return a.settle(nil)
+}
+
+func (a *memoryAcknowledger) nack(requeue bool) error {
+	return a.settle(func(m *MemoryBroker, msg *memoryMessage) {
+		if requeue {
+			msg.redelivered = true
+			m.queue = append([]*memoryMessage{msg}, m.queue...)
+			return
+		}
+		m.dead = append(m.dead, &DeadLetter{
+			Body:    msg.body,
+			Retries: RetryCount(msg.headers),
+			Reason:  "rejected",
+			Time:    time.Now(),
+		})
+	})
+}
+
+func (a *memoryAcknowledger) retry(headers map[string]interface{}) error {
+	return a.settle(func(m *MemoryBroker, msg *memoryMessage) {
+		m.queue = append(m.queue, &memoryMessage{body: msg.body, headers: headers})
+	})
+}
+
+// settle 移除未应答的消息并在同一临界区内执行 then，唤醒因 prefetch 等待的消费者
+func (a *memoryAcknowledger) settle(then func(m *MemoryBroker, msg *memoryMessage)) error {
+	m := a.broker
+	m.Lock()
+	defer m.Unlock()
+	msg := m.remove(a.tag)
+	if msg == nil {
+		return ErrStaleDelivery
+	}
+	if then != nil {
+		then(m, msg)
+	}
+	m.notify()
+	return nil
+}
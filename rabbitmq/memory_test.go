@@ -0,0 +1,470 @@
+package rabbitmq
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"litemall/model"
+	"litemall/repository"
+	"litemall/service"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// fakeOrderService 按请求 ID 去重的订单服务，可以让指定请求先失败若干次
+type fakeOrderService struct {
+	service.IOrderService
+	// 请求 ID 对应的订单 ID
+	orders map[string]int64
+	// 请求 ID 剩余的失败次数
+	failures map[string]int
+	// 库存不足的商品
+	soldOut map[int64]bool
+	sync.Mutex
+}
+
+func (s *fakeOrderService) InsertOrderByMessage(message *model.Message) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.insert(message)
+}
+
+func (s *fakeOrderService) InsertOrdersByMessage(messages []*model.Message) ([]int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	// 任意一条失败时整批不生效
+	for _, message := range messages {
+		if s.failures[message.RequestID] > 0 {
+			s.failures[message.RequestID]--
+			return nil, errors.New("数据库错误")
+		}
+		if s.soldOut[message.ProductID] {
+			return nil, repository.ErrSoldOut
+		}
+	}
+	ids := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		id, _ := s.insert(message)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *fakeOrderService) insert(message *model.Message) (int64, error) {
+	if s.failures[message.RequestID] > 0 {
+		s.failures[message.RequestID]--
+		return 0, errors.New("数据库错误")
+	}
+	if s.soldOut[message.ProductID] {
+		return 0, repository.ErrSoldOut
+	}
+	if id, ok := s.orders[message.RequestID]; ok {
+		return id, nil
+	}
+	if s.orders == nil {
+		s.orders = make(map[string]int64)
+	}
+	id := int64(len(s.orders) + 1)
+	s.orders[message.RequestID] = id
+	return id, nil
+}
+
+func (s *fakeOrderService) count() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.orders)
+}
+
+// publishOrder 发布订单消息
+func publishOrder(t *testing.T, p Publisher, userID, productID int64, requestID string) {
+	t.Helper()
+	body, err := json.Marshal(model.NewMessage(userID, productID, requestID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Publish(body); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitEmpty 等待队列中的消息全部应答
+func waitEmpty(t *testing.T, broker *MemoryBroker) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("队列中还有 %d 条消息未应答", broker.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOrderConsumer(t *testing.T) {
+	const n = 50
+	broker := NewMemoryBroker()
+	orderService := &fakeOrderService{
+		// 一条消息失败两次后成功，一条消息一直失败
+		failures: map[string]int{"retry": 2, "fail": 100},
+		// 库存不足的订单不重试
+		soldOut: map[int64]bool{2: true},
+	}
+
+	for i := 0; i < n; i++ {
+		publishOrder(t, broker, int64(i), 1, strconv.Itoa(i))
+	}
+	// 重复消息只创建一个订单
+	publishOrder(t, broker, 0, 1, "0")
+	publishOrder(t, broker, n, 1, "retry")
+	publishOrder(t, broker, n+1, 1, "fail")
+	publishOrder(t, broker, n+2, 2, "soldout")
+	if err := broker.Publish([]byte("bad")); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := NewOrderConsumer(broker, orderService)
+	consumer.RetryDelay = time.Millisecond
+	consumer.Concurrency = 4
+	consumer.Prefetch = 16
+	consumer.BatchSize = 4
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+
+	waitEmpty(t, broker)
+	cancel()
+	<-done
+
+	if got := orderService.count(); got != n+1 {
+		t.Errorf("创建了 %d 个订单，期望 %d 个", got, n+1)
+	}
+	letters := broker.DeadLetters()
+	if len(letters) != 3 {
+		t.Fatalf("死信 %d 条，期望 3 条", len(letters))
+	}
+	for _, letter := range letters {
+		message := &model.Message{}
+		if json.Unmarshal(letter.Body, message) != nil || message.RequestID == "soldout" {
+			if letter.Retries != 0 {
+				t.Errorf("无法处理的消息 %s 重试了 %d 次", letter.Body, letter.Retries)
+			}
+		} else if letter.Retries != DefaultMaxRetries {
+			t.Errorf("失败的消息重试了 %d 次，期望 %d 次", letter.Retries, DefaultMaxRetries)
+		}
+	}
+}
+
+func TestOrderConsumerStop(t *testing.T) {
+	broker := NewMemoryBroker()
+	orderService := &fakeOrderService{}
+	consumer := NewOrderConsumer(broker, orderService)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+
+	publishOrder(t, broker, 1, 1, "1")
+	waitEmpty(t, broker)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("停止后消费者没有退出")
+	}
+
+	// 停止后发布的消息留在队列中
+	publishOrder(t, broker, 2, 1, "2")
+	time.Sleep(10 * time.Millisecond)
+	if broker.Len() != 1 || orderService.count() != 1 {
+		t.Errorf("停止后仍在消费，队列 %d 条，订单 %d 个", broker.Len(), orderService.count())
+	}
+}
+
+func TestMemoryBrokerRedelivery(t *testing.T) {
+	broker := NewMemoryBroker()
+	for i := 0; i < 3; i++ {
+		if err := broker.Publish([]byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliveries, err := broker.Consume(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := <-deliveries, <-deliveries
+	// 未应答的消息达到 prefetch 后不再投递
+	select {
+	case d := <-deliveries:
+		t.Fatalf("超过 prefetch 仍投递了 %s", d.Body)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err = first.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	third := <-deliveries
+	if string(third.Body) != "2" {
+		t.Fatalf("投递了 %s，期望 2", third.Body)
+	}
+
+	// 断开后未应答的消息按原顺序重新投递
+	broker.Disconnect()
+	if _, ok := <-deliveries; ok {
+		t.Fatal("断开后 channel 没有关闭")
+	}
+	if err = second.Ack(); !errors.Is(err, ErrStaleDelivery) {
+		t.Fatalf("断开前的消息应答返回 %v", err)
+	}
+	deliveries, err = broker.Consume(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1", "2"} {
+		d := <-deliveries
+		if string(d.Body) != want || !d.Redelivered {
+			t.Fatalf("重新投递了 %s redelivered=%v，期望 %s", d.Body, d.Redelivered, want)
+		}
+		d.Ack()
+	}
+
+	broker.Destroy()
+	if _, ok := <-deliveries; ok {
+		t.Fatal("关闭后 channel 没有关闭")
+	}
+	if err = broker.Publish(nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("关闭后发布返回 %v", err)
+	}
+}
+
+// orderStore 内存中的订单表、商品库存和 outbox 的测试驱动，订单表的 request_id 有唯一索引
+// 事务期间独占全部数据，回滚时恢复到开始事务前的状态
+type orderStore struct {
+	// 请求 ID 对应的订单 ID
+	orders map[string]int64
+	// 商品库存
+	stock map[int64]int64
+	// 写入的订单创建事件数
+	events int
+	// 事务开始前的数据
+	savedOrders map[string]int64
+	savedStock  map[int64]int64
+	savedEvents int
+	sync.Mutex
+}
+
+func (s *orderStore) Open(name string) (driver.Conn, error) {
+	return &orderConn{store: s}, nil
+}
+
+func (s *orderStore) Connect(ctx context.Context) (driver.Conn, error) {
+	return s.Open("")
+}
+
+func (s *orderStore) Driver() driver.Driver {
+	return s
+}
+
+func (s *orderStore) count() (orders int, stock int64, events int) {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.orders), s.stock[1], s.events
+}
+
+type orderConn struct {
+	store *orderStore
+	inTx  bool
+}
+
+func (c *orderConn) Prepare(query string) (driver.Stmt, error) {
+	return &orderStmt{conn: c, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+
+func (c *orderConn) Close() error {
+	return nil
+}
+
+func (c *orderConn) Begin() (driver.Tx, error) {
+	s := c.store
+	s.Lock()
+	s.savedOrders = make(map[string]int64, len(s.orders))
+	for k, v := range s.orders {
+		s.savedOrders[k] = v
+	}
+	s.savedStock = make(map[int64]int64, len(s.stock))
+	for k, v := range s.stock {
+		s.savedStock[k] = v
+	}
+	s.savedEvents = s.events
+	c.inTx = true
+	return c, nil
+}
+
+func (c *orderConn) Commit() error {
+	c.inTx = false
+	c.store.Unlock()
+	return nil
+}
+
+func (c *orderConn) Rollback() error {
+	s := c.store
+	s.orders, s.stock, s.events = s.savedOrders, s.savedStock, s.savedEvents
+	c.inTx = false
+	s.Unlock()
+	return nil
+}
+
+type orderStmt struct {
+	conn  *orderConn
+	query string
+}
+
+func (s *orderStmt) Close() error {
+	return nil
+}
+
+func (s *orderStmt) NumInput() int {
+	return -1
+}
+
+func (s *orderStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if !s.conn.inTx {
+		return nil, errors.New("只支持在事务中执行")
+	}
+	store := s.conn.store
+	switch {
+	case strings.HasPrefix(s.query, "insert into `order`"):
+		requestID, _ := args[3].(string)
+		if _, ok := store.orders[requestID]; ok {
+			return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry '" + requestID + "' for key 'uk_request_id'"}
+		}
+		id := int64(len(store.orders) + 1)
+		store.orders[requestID] = id
+		return orderResult{id: id, rows: 1}, nil
+	case strings.HasPrefix(s.query, "update product"):
+		qty, productID := args[0].(int64), args[1].(int64)
+		if store.stock[productID] < qty {
+			return orderResult{}, nil
+		}
+		store.stock[productID] -= qty
+		return orderResult{rows: 1}, nil
+	case strings.HasPrefix(s.query, "insert into outbox"):
+		store.events++
+		return orderResult{id: int64(store.events), rows: 1}, nil
+	}
+	return nil, errors.New("不支持的语句: " + s.query)
+}
+
+func (s *orderStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !s.conn.inTx || !strings.HasPrefix(s.query, "select order_id from `order` where request_id") {
+		return nil, errors.New("不支持的查询: " + s.query)
+	}
+	requestID, _ := args[0].(string)
+	rows := &orderRows{}
+	if id, ok := s.conn.store.orders[requestID]; ok {
+		rows.ids = []int64{id}
+	}
+	return rows, nil
+}
+
+type orderResult struct {
+	id, rows int64
+}
+
+func (r orderResult) LastInsertId() (int64, error) {
+	return r.id, nil
+}
+
+func (r orderResult) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
+
+type orderRows struct {
+	ids []int64
+}
+
+func (r *orderRows) Columns() []string {
+	return []string{"order_id"}
+}
+
+func (r *orderRows) Close() error {
+	return nil
+}
+
+func (r *orderRows) Next(dest []driver.Value) error {
+	if len(r.ids) == 0 {
+		return io.EOF
+	}
+	dest[0], r.ids = r.ids[0], r.ids[1:]
+	return nil
+}
+
+func TestOrderConsumerRedeliveryDedupe(t *testing.T) {
+	store := &orderStore{
+		orders: make(map[string]int64),
+		stock:  map[int64]int64{1: 10},
+	}
+	db := sql.OpenDB(store)
+	defer db.Close()
+	orderService := service.NewOrderService(repository.NewOrderManager("order", db))
+
+	broker := NewMemoryBroker()
+	for i := 0; i < 4; i++ {
+		publishOrder(t, broker, int64(i), 1, strconv.Itoa(i))
+	}
+	// 前两条消息的订单已经提交，应答前连接断开
+	deliveries, err := broker.Consume(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		message, err := parseOrder((<-deliveries).Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = orderService.InsertOrderByMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broker.Disconnect()
+
+	// 重新投递的消息和新消息在同一批次中处理，由 request_id 唯一索引去重
+	consumer := NewOrderConsumer(broker, orderService)
+	consumer.Concurrency = 1
+	consumer.Prefetch = 4
+	consumer.BatchSize = 4
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	waitEmpty(t, broker)
+	cancel()
+	<-done
+
+	// 重新投递的消息逐条处理时也不会重复创建订单
+	if id, err := orderService.InsertOrderByMessage(model.NewMessage(0, 1, "0")); err != nil || id != 1 {
+		t.Errorf("重复请求返回订单 %d, %v，期望已有订单 1", id, err)
+	}
+
+	orders, stock, events := store.count()
+	if orders != 4 || stock != 6 || events != 4 {
+		t.Errorf("订单 %d 个，库存 %d，事件 %d 条，期望 4 个订单、库存 6、4 条事件", orders, stock, events)
+	}
+	if letters := broker.DeadLetters(); len(letters) != 0 {
+		t.Errorf("死信 %d 条，期望没有", len(letters))
+	}
+}
@@ -0,0 +1,90 @@
+// Package main 事件发布服务
+// 读取 outbox 中待发布的事件，以事件类型为路由键发布到订单事件交换机，
+// 服务端确认后标记为已发布。发布成功但标记失败的事件会再次发布，
+// 下游系统按事件中的请求 ID 去重。只运行一个实例，保证事件按写入顺序发布
+//
+//	go run relay.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"litemall/common"
+	"litemall/config"
+	"litemall/rabbitmq"
+	"litemall/repository"
+	"litemall/service"
+)
+
+func main() {
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.RabbitMQ.EventExchange == "" {
+		log.Fatal("未配置 rabbitmq.event_exchange，无法发布事件")
+	}
+	common.SetMySQLDSN(cfg.MySQL.DSN)
+	rabbitmq.MQURL = cfg.RabbitMQ.URL
+
+	db, err := common.NewMySQLConn()
+	if err != nil {
+		log.Fatal(err)
+	}
+	outbox := repository.NewOutboxManager("outbox", db)
+	outboxService := service.NewOutboxService(outbox)
+
+	// 下游系统各自申请队列并绑定，这里只发布
+	rabbitmqTopic := rabbitmq.NewRabbitMQTopic(cfg.RabbitMQ.EventExchange, "", "")
+	rabbitmqTopic.ConfirmTimeout = cfg.RabbitMQ.ConfirmTimeout
+	defer rabbitmqTopic.Destroy()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	log.Println("[*] Relaying outbox events, To exit press CTRL+C")
+	for {
+		// 一批全部发布后立即读取下一批
+		if relay(outboxService, rabbitmqTopic, cfg.Relay.BatchSize) == cfg.Relay.BatchSize {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.Relay.Interval):
+		}
+	}
+}
+
+// relay 按写入顺序发布一批事件，遇到错误时停止，返回已发布的事件数
+func relay(outboxService service.IOutboxService, mq *rabbitmq.RabbitMQ, limit int) int {
+	if !mq.Connected() {
+		return 0
+	}
+	events, err := outboxService.GetPendingEvents(limit)
+	if err != nil {
+		fmt.Println("读取待发布事件失败:", err)
+		return 0
+	}
+	for i, event := range events {
+		if err = mq.PublishKey(event.EventType, []byte(event.Payload)); err != nil {
+			fmt.Println("事件发布失败:", event.ID, err)
+			return i
+		}
+		// 标记失败时下一轮会再次发布
+		if err = outboxService.MarkEventSent(event.ID); err != nil {
+			fmt.Println("标记事件已发布失败:", event.ID, err)
+			return i
+		}
+	}
+	return len(events)
+}
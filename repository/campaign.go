@@ -0,0 +1,217 @@
+package repository
+
+import (
+	"database/sql"
+
+	"litemall/common"
+	"litemall/model"
+)
+
+// ICampaign 秒杀活动模型对应的接口
+type ICampaign interface {
+	Conn() error
+	Insert(*model.Campaign) (int64, error)
+	Delete(int64) bool
+	Update(*model.Campaign) error
+	SelectByKey(int64) (*model.Campaign, error)
+	SelectAll() ([]*model.Campaign, error)
+	SelectByProductID(int64) ([]*model.Campaign, error)
+}
+
+// CampaignManager 秒杀活动接口的具体实现
+type CampaignManager struct {
+	table   string
+	sqlConn *sql.DB
+}
+
+// NewCampaignManager 创建
+func NewCampaignManager(table string, sqlConn *sql.DB) ICampaign {
+	return &CampaignManager{
+		table:   table,
+		sqlConn: sqlConn,
+	}
+}
+
+// campaignColumns 查询字段，时间统一格式化后再映射到结构体
+const campaignColumns = `campaign_id, product_id, campaign_price, campaign_number,
+			date_format(start_time, '%Y-%m-%d %H:%i:%s') as start_time,
+			date_format(end_time, '%Y-%m-%d %H:%i:%s') as end_time,
+			user_limit`
+
+// Conn 初始化数据库连接
+func (c *CampaignManager) Conn() error {
+	if c.sqlConn == nil {
+		mysql, err := common.NewMySQLConn()
+		if err != nil {
+			return err
+		}
+		c.sqlConn = mysql
+	}
+	if c.table == "" {
+		c.table = "campaign"
+	}
+	return nil
+}
+
+// Insert 插入
+func (c *CampaignManager) Insert(campaign *model.Campaign) (id int64, err error) {
+	// 判断连接是否存在
+	if err = c.Conn(); err != nil {
+		return
+	}
+
+	// 准备 sql
+	sql := `insert
+			into campaign
+			(product_id, campaign_price, campaign_number, start_time, end_time, user_limit)
+			values
+			(?, ?, ?, ?, ?, ?)`
+	stmt, err := c.sqlConn.Prepare(sql)
+	if err != nil {
+		return 0, err
+	}
+
+	// 执行 sql
+	result, err := stmt.Exec(campaign.ProductID, campaign.Price, campaign.Number,
+		campaign.StartTime, campaign.EndTime, campaign.UserLimit)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// Delete 删除
+func (c *CampaignManager) Delete(id int64) bool {
+	// 判断连接是否存在
+	if err := c.Conn(); err != nil {
+		return false
+	}
+
+	// 准备 sql
+	sql := `delete
+			from campaign
+			where campaign_id = ?`
+	stmt, err := c.sqlConn.Prepare(sql)
+	if err != nil {
+		return false
+	}
+
+	// 执行 sql
+	_, err = stmt.Exec(id)
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+// Update 更新
+func (c *CampaignManager) Update(campaign *model.Campaign) (err error) {
+	// 判断连接是否存在
+	if err = c.Conn(); err != nil {
+		return
+	}
+
+	// 准备 sql
+	sql := `update campaign
+			set product_id = ?,
+				campaign_price = ?,
+				campaign_number = ?,
+				start_time = ?,
+				end_time = ?,
+				user_limit = ?
+			where campaign_id = ?`
+	stmt, err := c.sqlConn.Prepare(sql)
+	if err != nil {
+		return
+	}
+
+	// 执行 sql
+	_, err = stmt.Exec(campaign.ProductID, campaign.Price, campaign.Number,
+		campaign.StartTime, campaign.EndTime, campaign.UserLimit, campaign.ID)
+	return
+}
+
+// SelectByKey 查询指定 ID 的记录
+func (c *CampaignManager) SelectByKey(id int64) (campaign *model.Campaign, err error) {
+	// 判断连接是否存在
+	if err = c.Conn(); err != nil {
+		return &model.Campaign{}, err
+	}
+
+	// 准备 sql
+	sql := `select ` + campaignColumns + `
+			from campaign
+			where campaign_id = ?`
+
+	// 执行 sql
+	row, err := c.sqlConn.Query(sql, id)
+	if err != nil {
+		return &model.Campaign{}, err
+	}
+	defer row.Close()
+	result := common.GetResultRow(row)
+	if len(result) == 0 {
+		return &model.Campaign{}, err
+	}
+
+	campaign = new(model.Campaign)
+	common.DataToStructByTagSQL(result, campaign)
+	return
+}
+
+// SelectAll 查询所有记录
+func (c *CampaignManager) SelectAll() (campaigns []*model.Campaign, err error) {
+	// 判断连接是否存在
+	if err = c.Conn(); err != nil {
+		return nil, err
+	}
+
+	// 准备 sql
+	sql := `select ` + campaignColumns + `
+			from campaign
+			order by start_time desc`
+
+	return c.selectRows(sql)
+}
+
+// SelectByProductID 查询商品的所有秒杀活动，按开始时间排序
+func (c *CampaignManager) SelectByProductID(productID int64) (campaigns []*model.Campaign, err error) {
+	// 判断连接是否存在
+	if err = c.Conn(); err != nil {
+		return nil, err
+	}
+
+	// 准备 sql
+	sql := `select ` + campaignColumns + `
+			from campaign
+			where product_id = ?
+			order by start_time`
+
+	return c.selectRows(sql, productID)
+}
+
+// selectRows 执行查询并映射为活动列表
+func (c *CampaignManager) selectRows(sql string, args ...interface{}) (campaigns []*model.Campaign, err error) {
+	// 执行 sql
+	rows, err := c.sqlConn.Query(sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := common.GetResultRows(rows)
+	if len(result) == 0 {
+		return nil, err
+	}
+
+	// GetResultRows 返回的 map 无序，按行号取出保持排序
+	for i := 0; i < len(result); i++ {
+		campaign := &model.Campaign{}
+		common.DataToStructByTagSQL(result[i], campaign)
+		campaigns = append(campaigns, campaign)
+	}
+
+	return
+}
@@ -2,15 +2,21 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 
 	"litemall/common"
 	"litemall/model"
 )
 
+// ErrOrderExists 请求 ID 对应的订单已存在
+var ErrOrderExists = errors.New("订单已存在")
+
 // IOrder 订单模型对应的接口
 type IOrder interface {
 	Conn() error
 	Insert(*model.Order) (int64, error)
+	InsertWithStock(*model.Order) (int64, error)
+	InsertBatchWithStock([]*model.Order) ([]int64, error)
 	Delete(int64) bool
 	Update(*model.Order) error
 	SelectByKey(int64) (*model.Order, error)
@@ -57,16 +63,16 @@ func (o *OrderManager) Insert(order *model.Order) (id int64, err error) {
 	// 准备 sql
 	sql := "insert " +
 		"into `order` " +
-		"(user_id, product_id, order_status) " +
+		"(user_id, product_id, order_status, request_id) " +
 		"values " +
-		"(?, ?, ?)"
+		"(?, ?, ?, ?)"
 	stmt, err := o.sqlConn.Prepare(sql)
 	if err != nil {
 		return 0, err
 	}
 
 	// 执行 sql
-	result, err := stmt.Exec(order.UserID, order.ProductID, order.Status)
+	result, err := stmt.Exec(order.UserID, order.ProductID, order.Status, common.NullString(order.RequestID))
 	if err != nil {
 		return 0, err
 	}
@@ -74,6 +80,97 @@ func (o *OrderManager) Insert(order *model.Order) (id int64, err error) {
 	return result.LastInsertId()
 }
 
+// InsertWithStock 在同一事务中创建订单、扣除商品库存并写入 outbox
+// 库存不足时不创建订单并返回 ErrSoldOut
+// 订单表的 request_id 需要唯一索引，同一请求重复创建时不会重复扣除库存，
+// 返回已有订单 ID 和 ErrOrderExists：
+//
+//	alter table `order` add column request_id varchar(32) null, add unique key uk_request_id (request_id)
+func (o *OrderManager) InsertWithStock(order *model.Order) (id int64, err error) {
+	// 判断连接是否存在
+	if err = o.Conn(); err != nil {
+		return
+	}
+
+	tx, err := o.sqlConn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	// 提交后回滚不会生效
+	defer tx.Rollback()
+
+	if id, err = insertWithStock(tx, order); err != nil {
+		return id, err
+	}
+	return id, tx.Commit()
+}
+
+// InsertBatchWithStock 在同一事务中批量创建订单并扣除商品库存
+// 已存在的订单不会重复创建，返回已有订单 ID，任意一个订单失败或库存不足时整批回滚
+func (o *OrderManager) InsertBatchWithStock(orders []*model.Order) (ids []int64, err error) {
+	// 判断连接是否存在
+	if err = o.Conn(); err != nil {
+		return
+	}
+
+	tx, err := o.sqlConn.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// 提交后回滚不会生效
+	defer tx.Rollback()
+
+	ids = make([]int64, 0, len(orders))
+	for _, order := range orders {
+		id, err := insertWithStock(tx, order)
+		if err != nil && !errors.Is(err, ErrOrderExists) {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, tx.Commit()
+}
+
+// insertWithStock 在事务中创建订单、扣除商品库存并写入订单创建事件
+// 请求 ID 重复时只回滚当前语句，返回已有订单 ID 和 ErrOrderExists
+func insertWithStock(tx *sql.Tx, order *model.Order) (id int64, err error) {
+	// 创建订单，请求 ID 重复时违反唯一索引
+	sql := "insert " +
+		"into `order` " +
+		"(user_id, product_id, order_status, request_id) " +
+		"values " +
+		"(?, ?, ?, ?)"
+	result, err := tx.Exec(sql, order.UserID, order.ProductID, order.Status, common.NullString(order.RequestID))
+	if common.IsDuplicateEntry(err) {
+		sql = "select order_id " +
+			"from `order` " +
+			"where request_id = ?"
+		if err = tx.QueryRow(sql, order.RequestID).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, ErrOrderExists
+	}
+	if err != nil {
+		return 0, err
+	}
+	if id, err = result.LastInsertId(); err != nil {
+		return 0, err
+	}
+
+	// 扣除商品库存，库存不足时返回 ErrSoldOut，事务回滚
+	if err = decrementStock(tx, order.ProductID, 1); err != nil {
+		return 0, err
+	}
+
+	// 写入订单创建事件，提交后由 relay 发布
+	event := model.NewOrderCreatedEvent(order)
+	event.OrderID = id
+	if err = insertOutbox(tx, event.Type, event); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
 // Delete 删除
 func (o *OrderManager) Delete(id int64) bool {
 	// 判断连接是否存在
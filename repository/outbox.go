@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"litemall/common"
+	"litemall/model"
+)
+
+// IOutbox 事件发件箱对应的接口
+type IOutbox interface {
+	Conn() error
+	SelectPending(int) ([]*model.Outbox, error)
+	MarkSent(int64) error
+}
+
+// OutboxManager 事件发件箱接口的具体实现
+// 事件与业务数据在同一事务中写入，由 relay 发布后标记为已发布：
+//
+//	create table outbox (
+//	    outbox_id  bigint primary key auto_increment,
+//	    event_type varchar(64) not null,
+//	    payload    text not null,
+//	    status     tinyint not null default 0,
+//	    created_at datetime not null default current_timestamp,
+//	    sent_at    datetime null,
+//	    key idx_status (status, outbox_id)
+//	)
+type OutboxManager struct {
+	table   string
+	sqlConn *sql.DB
+}
+
+// NewOutboxManager 创建
+func NewOutboxManager(table string, sqlConn *sql.DB) IOutbox {
+	return &OutboxManager{
+		table:   table,
+		sqlConn: sqlConn,
+	}
+}
+
+// Conn 初始化数据库连接
+func (o *OutboxManager) Conn() error {
+	if o.sqlConn == nil {
+		mysql, err := common.NewMySQLConn()
+		if err != nil {
+			return err
+		}
+		o.sqlConn = mysql
+	}
+	if o.table == "" {
+		o.table = "outbox"
+	}
+	return nil
+}
+
+// SelectPending 按写入顺序查询最早的 limit 条待发布事件
+func (o *OutboxManager) SelectPending(limit int) (events []*model.Outbox, err error) {
+	// 判断连接是否存在
+	if err = o.Conn(); err != nil {
+		return nil, err
+	}
+
+	// 准备 sql
+	sql := `select outbox_id, event_type, payload, status
+			from outbox
+			where status = ?
+			order by outbox_id
+			limit ?`
+
+	// 执行 sql
+	rows, err := o.sqlConn.Query(sql, model.OutboxPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// GetResultRows 返回的 map 无序，按行号取出保持写入顺序
+	result := common.GetResultRows(rows)
+	for i := 0; i < len(result); i++ {
+		event := &model.Outbox{}
+		common.DataToStructByTagSQL(result[i], event)
+		events = append(events, event)
+	}
+	return
+}
+
+// MarkSent 标记事件已发布
+func (o *OutboxManager) MarkSent(id int64) (err error) {
+	// 判断连接是否存在
+	if err = o.Conn(); err != nil {
+		return
+	}
+
+	sql := `update outbox
+			set status = ?, sent_at = now()
+			where outbox_id = ?`
+	_, err = o.sqlConn.Exec(sql, model.OutboxSent, id)
+	return
+}
+
+// insertOutbox 在事务中写入待发布事件
+func insertOutbox(tx *sql.Tx, eventType string, event interface{}) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	sql := "insert " +
+		"into outbox " +
+		"(event_type, payload, status) " +
+		"values " +
+		"(?, ?, ?)"
+	_, err = tx.Exec(sql, eventType, string(payload), model.OutboxPending)
+	return err
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+
+	"litemall/model"
+)
+
+// outboxDriver 返回固定行的测试驱动，查询结果按 outbox_id 升序
+type outboxDriver struct {
+	rows int
+}
+
+func (d *outboxDriver) Open(name string) (driver.Conn, error) {
+	return &outboxConn{rows: d.rows}, nil
+}
+
+type outboxConn struct {
+	rows int
+}
+
+func (c *outboxConn) Prepare(query string) (driver.Stmt, error) {
+	return &outboxStmt{rows: c.rows}, nil
+}
+
+func (c *outboxConn) Close() error {
+	return nil
+}
+
+func (c *outboxConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("不支持事务")
+}
+
+type outboxStmt struct {
+	rows int
+}
+
+func (s *outboxStmt) Close() error {
+	return nil
+}
+
+func (s *outboxStmt) NumInput() int {
+	return -1
+}
+
+func (s *outboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("不支持执行")
+}
+
+func (s *outboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &outboxRows{total: s.rows}, nil
+}
+
+type outboxRows struct {
+	next, total int
+}
+
+func (r *outboxRows) Columns() []string {
+	return []string{"outbox_id", "event_type", "payload", "status"}
+}
+
+func (r *outboxRows) Close() error {
+	return nil
+}
+
+func (r *outboxRows) Next(dest []driver.Value) error {
+	if r.next >= r.total {
+		return io.EOF
+	}
+	r.next++
+	dest[0] = int64(r.next)
+	dest[1] = []byte(model.OrderCreated)
+	dest[2] = []byte(`{"order_id":` + strconv.Itoa(r.next) + `}`)
+	dest[3] = int64(model.OutboxPending)
+	return nil
+}
+
+func TestOutboxSelectPendingOrder(t *testing.T) {
+	const n = 100
+	sql.Register("outboxtest", &outboxDriver{rows: n})
+	db, err := sql.Open("outboxtest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	events, err := NewOutboxManager("outbox", db).SelectPending(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != n {
+		t.Fatalf("查询到 %d 条事件，期望 %d 条", len(events), n)
+	}
+	// 必须保持查询结果的顺序，relay 按此顺序发布
+	for i, event := range events {
+		if event.ID != int64(i+1) {
+			t.Fatalf("第 %d 条事件 ID 为 %d，期望 %d", i, event.ID, i+1)
+		}
+	}
+}
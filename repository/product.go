@@ -3,12 +3,15 @@ package repository
 
 import (
 	"database/sql"
-	"strconv"
+	"errors"
 
 	"litemall/common"
 	"litemall/model"
 )
 
+// ErrSoldOut 商品库存不足
+var ErrSoldOut = errors.New("商品已售罄")
+
 // IProduct 商品模型对应的接口
 type IProduct interface {
 	Conn() error
@@ -18,6 +21,7 @@ type IProduct interface {
 	SelectByKey(int64) (*model.Product, error)
 	SelectAll() ([]*model.Product, error)
 	SubProductNum(int64) error
+	DecrementStock(int64, int64) error
 }
 
 // ProductManager 商品接口的具体实现
@@ -193,14 +197,38 @@ func (p *ProductManager) SelectAll() (products []*model.Product, err error) {
 
 // SubProductNum 商品减一
 func (p *ProductManager) SubProductNum(productID int64) error {
+	return p.DecrementStock(productID, 1)
+}
+
+// DecrementStock 扣除商品库存，库存不足时不扣除并返回 ErrSoldOut
+func (p *ProductManager) DecrementStock(productID, qty int64) error {
 	if err := p.Conn(); err != nil {
 		return err
 	}
-	sql := "update product set productNum = productNum - 1 where ID = ?"
-	stmt, err := p.sqlConn.Prepare(sql)
+	return decrementStock(p.sqlConn, productID, qty)
+}
+
+// execer 可以执行 sql 的数据库连接或事务
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// decrementStock 使用条件更新扣除商品库存，并发扣除时不会超卖
+// 商品不存在时同样返回 ErrSoldOut
+func decrementStock(db execer, productID, qty int64) error {
+	sql := `update product
+			set product_number = product_number - ?
+			where product_id = ? and product_number >= ?`
+	result, err := db.Exec(sql, qty, productID, qty)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(strconv.FormatInt(productID, 10))
-	return err
+	if rows == 0 {
+		return ErrSoldOut
+	}
+	return nil
 }
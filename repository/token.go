@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"litemall/common"
+	"litemall/token"
+)
+
+// RevocationManager 基于数据库的令牌注销列表，多个服务共享
+type RevocationManager struct {
+	table   string
+	sqlConn *sql.DB
+}
+
+// NewRevocationManager 创建
+func NewRevocationManager(table string, sqlConn *sql.DB) token.Revoker {
+	return &RevocationManager{
+		table:   table,
+		sqlConn: sqlConn,
+	}
+}
+
+// Conn 初始化数据库连接
+func (r *RevocationManager) Conn() error {
+	if r.sqlConn == nil {
+		mysql, err := common.NewMySQLConn()
+		if err != nil {
+			return err
+		}
+		r.sqlConn = mysql
+	}
+	if r.table == "" {
+		r.table = "token_revocation"
+	}
+	return nil
+}
+
+// Revoke 注销令牌，同时清理已过期的记录
+func (r *RevocationManager) Revoke(id string, expireAt time.Time) (err error) {
+	if err = r.Conn(); err != nil {
+		return
+	}
+
+	sql := `insert ignore
+			into token_revocation
+			(token_id, expire_at)
+			values
+			(?, ?)`
+	if _, err = r.sqlConn.Exec(sql, id, expireAt); err != nil {
+		return
+	}
+
+	sql = `delete
+			from token_revocation
+			where expire_at < ?`
+	_, err = r.sqlConn.Exec(sql, time.Now())
+	return
+}
+
+// IsRevoked 判断令牌是否已注销
+func (r *RevocationManager) IsRevoked(id string) (revoked bool, err error) {
+	if err = r.Conn(); err != nil {
+		return
+	}
+
+	sql := `select count(*)
+			from token_revocation
+			where token_id = ?`
+	var count int
+	if err = r.sqlConn.QueryRow(sql, id).Scan(&count); err != nil {
+		return
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,210 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// retryBackoff 建立连接失败后的重试间隔
+	retryBackoff = 50 * time.Millisecond
+	// maxIdle 连接池中的连接最长空闲时间，早于服务端的 idleTimeout 丢弃，
+	// 避免请求写入已被服务端关闭的连接后无法重试
+	maxIdle = idleTimeout / 2
+)
+
+// conn 连接池中的连接
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	// 放回连接池的时间
+	idleAt time.Time
+}
+
+// Client 内部协议客户端，复用到同一地址的持久连接
+// 请求会修改购买记录和库存，不是幂等的，
+// 只在连接未建立或请求没有写出时重试，请求写出后即使没有收到应答也不重试
+type Client struct {
+	addr   string
+	secret []byte
+	// 每次请求的超时时间
+	timeout time.Duration
+	// 建立连接失败时的重试次数
+	retries int
+	// 空闲连接
+	idle chan *conn
+	seq  atomic.Uint32
+}
+
+// NewClient 创建客户端，最多保持 poolSize 个空闲连接
+func NewClient(addr string, secret []byte, timeout time.Duration, retries, poolSize int) *Client {
+	return &Client{
+		addr:    addr,
+		secret:  secret,
+		timeout: timeout,
+		retries: retries,
+		idle:    make(chan *conn, poolSize),
+	}
+}
+
+// Call 发送请求并等待应答，requestID 只用于获取和归还数量控制权限
+func (c *Client) Call(ctx context.Context, op Op, userID, productID int64, requestID RequestID) (Code, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req := &Message{
+		Op:        op,
+		Seq:       c.seq.Add(1),
+		UserID:    userID,
+		ProductID: productID,
+		RequestID: requestID,
+	}
+	for attempt := 0; ; attempt++ {
+		cn, pooled, err := c.get(ctx)
+		if err != nil {
+			if attempt >= c.retries || !isDialError(err) {
+				return CodeError, err
+			}
+			select {
+			case <-ctx.Done():
+				return CodeError, err
+			case <-time.After(retryBackoff << attempt):
+			}
+			continue
+		}
+
+		reply, written, err := c.roundTrip(ctx, cn, req)
+		if err == nil {
+			c.put(cn)
+			return reply.Code, nil
+		}
+		cn.Close()
+		// 空闲连接已被对端关闭，写入失败时对端收不到完整的帧，请求没有被处理，换新连接重试
+		// 写入成功后对端可能已经处理，读取应答失败时不能重试
+		if pooled && !written && ctx.Err() == nil {
+			continue
+		}
+		return CodeError, err
+	}
+}
+
+// Close 关闭所有空闲连接
+func (c *Client) Close() {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// roundTrip 在连接上发送一次请求，written 表示请求是否已完整写出
+func (c *Client) roundTrip(ctx context.Context, cn *conn, req *Message) (reply *Message, written bool, err error) {
+	deadline, _ := ctx.Deadline()
+	cn.SetDeadline(deadline)
+	// 调用方取消时立即中断读写
+	stop := context.AfterFunc(ctx, func() {
+		cn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err = WriteMessage(cn, c.secret, req); err != nil {
+		return nil, false, err
+	}
+	reply, err = ReadMessage(cn.reader, c.secret)
+	if err != nil {
+		return nil, true, err
+	}
+	if reply.Op != req.Op|opReply || reply.Seq != req.Seq {
+		return nil, true, ErrFrame
+	}
+	return reply, true, nil
+}
+
+// get 从连接池获取连接，没有空闲连接时新建，空闲过久的连接直接关闭
+func (c *Client) get(ctx context.Context) (*conn, bool, error) {
+idle:
+	for {
+		select {
+		case cn := <-c.idle:
+			if time.Since(cn.idleAt) <= maxIdle {
+				return cn, true, nil
+			}
+			cn.Close()
+		default:
+			break idle
+		}
+	}
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, false, err
+	}
+	return &conn{Conn: netConn, reader: bufio.NewReader(netConn)}, false, nil
+}
+
+// put 归还连接，连接池已满时关闭
+func (c *Client) put(cn *conn) {
+	cn.SetDeadline(time.Time{})
+	cn.idleAt = time.Now()
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// isDialError 判断是否为建立连接失败
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Pool 按地址管理客户端
+type Pool struct {
+	secret   []byte
+	timeout  time.Duration
+	retries  int
+	poolSize int
+	clients  map[string]*Client
+	sync.Mutex
+}
+
+// NewPool 创建客户端池
+func NewPool(secret []byte, timeout time.Duration, retries, poolSize int) *Pool {
+	return &Pool{
+		secret:   secret,
+		timeout:  timeout,
+		retries:  retries,
+		poolSize: poolSize,
+		clients:  make(map[string]*Client),
+	}
+}
+
+// Client 获取到指定地址的客户端
+func (p *Pool) Client(addr string) *Client {
+	p.Lock()
+	defer p.Unlock()
+	client, ok := p.clients[addr]
+	if !ok {
+		client = NewClient(addr, p.secret, p.timeout, p.retries, p.poolSize)
+		p.clients[addr] = client
+	}
+	return client
+}
+
+// Close 关闭所有客户端的空闲连接
+func (p *Pool) Close() {
+	p.Lock()
+	defer p.Unlock()
+	for _, client := range p.clients {
+		client.Close()
+	}
+}
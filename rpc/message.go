@@ -0,0 +1,200 @@
+// Package rpc 网关节点与数量控制服务之间的内部协议
+// 基于持久 TCP 连接，每个帧由 4 字节长度前缀和定长消息体组成，
+// 消息体使用集群密钥计算 HMAC-SHA256 并截取前 16 字节作为签名，
+// 消息体带有发送时间，接收方拒绝超出 MaxSkew 的消息，服务端同时拒绝窗口内重复的请求
+//
+// 帧格式（大端序）：
+//
+//	长度(4) | 版本(1) | 操作(1) | 结果(1) | 保留(1) | 序号(4) | 时间(8) | 用户ID(8) | 商品ID(8) | 请求ID(16) | 签名(16)
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"litemall/service"
+)
+
+const (
+	// Version 协议版本，版本 2 增加发送时间，版本 3 增加请求 ID
+	Version = 3
+	// MaxSkew 消息发送时间与本机时间允许的最大偏差
+	MaxSkew = 5 * time.Second
+	// bodySize 消息体长度，不含长度前缀和签名
+	bodySize = 48
+	// macSize 签名长度
+	macSize = 16
+	// frameSize 长度前缀之后的帧长度
+	frameSize = bodySize + macSize
+)
+
+var (
+	// ErrFrame 帧格式错误
+	ErrFrame = errors.New("rpc 帧格式错误")
+	// ErrSignature 签名错误，集群密钥不一致或数据被篡改
+	ErrSignature = errors.New("rpc 签名错误")
+	// ErrStale 消息发送时间超出允许范围，或为重放的请求
+	ErrStale = errors.New("rpc 消息已过期或重复")
+	// ErrRequestID 请求 ID 格式错误
+	ErrRequestID = errors.New("rpc 请求 ID 格式错误")
+)
+
+// Op 操作类型
+type Op uint8
+
+const (
+	// OpCheckRight 在归属节点上校验并记录一次购买
+	OpCheckRight Op = iota + 1
+	// OpReleaseRight 撤销归属节点上的购买记录
+	OpReleaseRight
+	// OpGetOne 获取数量控制权限
+	OpGetOne
+	// OpReleaseOne 归还获取到的数量控制权限
+	OpReleaseOne
+
+	// opReply 应答标志，应答的操作为请求操作加上该标志
+	opReply Op = 0x80
+)
+
+// Code 处理结果
+type Code uint8
+
+const (
+	// CodeOK 成功
+	CodeOK Code = iota
+	// CodeDenied 拒绝，超出限购或已售罄
+	CodeDenied
+	// CodeNotFound 商品没有秒杀活动
+	CodeNotFound
+	// CodeNotStarted 秒杀活动尚未开始
+	CodeNotStarted
+	// CodeEnded 秒杀活动已结束
+	CodeEnded
+	// CodeError 服务端错误或不支持的操作
+	CodeError
+)
+
+// CampaignCode 将秒杀活动校验错误转换为结果
+func CampaignCode(err error) Code {
+	switch {
+	case err == nil:
+		return CodeOK
+	case errors.Is(err, service.ErrCampaignNotFound):
+		return CodeNotFound
+	case errors.Is(err, service.ErrCampaignNotStarted):
+		return CodeNotStarted
+	case errors.Is(err, service.ErrCampaignEnded):
+		return CodeEnded
+	}
+	return CodeError
+}
+
+// Reason 活动时间外的拒绝原因，其它结果返回 nil
+func (c Code) Reason() error {
+	switch c {
+	case CodeNotFound:
+		return service.ErrCampaignNotFound
+	case CodeNotStarted:
+		return service.ErrCampaignNotStarted
+	case CodeEnded:
+		return service.ErrCampaignEnded
+	}
+	return nil
+}
+
+// RequestID 订单请求 ID，与订单消息的请求 ID 相同
+type RequestID [16]byte
+
+// ParseRequestID 解析 common.NewRequestID 生成的十六进制请求 ID
+func ParseRequestID(s string) (RequestID, error) {
+	var id RequestID
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, ErrRequestID
+	}
+	copy(id[:], b)
+	return id, nil
+}
+
+// String 十六进制格式，与订单消息中的请求 ID 相同
+func (id RequestID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Message 请求或应答消息
+type Message struct {
+	Op   Op
+	Code Code
+	// 请求序号，应答与请求相同
+	Seq uint32
+	// 发送时间，由 WriteMessage 写入
+	Time      time.Time
+	UserID    int64
+	ProductID int64
+	// 获取和归还数量控制权限时为订单的请求 ID，其它操作为零值
+	RequestID RequestID
+}
+
+// WriteMessage 以当前时间为发送时间，编码并签名后写入一帧
+func WriteMessage(w io.Writer, secret []byte, msg *Message) error {
+	var frame [4 + frameSize]byte
+	binary.BigEndian.PutUint32(frame[0:], frameSize)
+	body := frame[4 : 4+bodySize]
+	body[0] = Version
+	body[1] = byte(msg.Op)
+	body[2] = byte(msg.Code)
+	binary.BigEndian.PutUint32(body[4:], msg.Seq)
+	binary.BigEndian.PutUint64(body[8:], uint64(time.Now().UnixNano()))
+	binary.BigEndian.PutUint64(body[16:], uint64(msg.UserID))
+	binary.BigEndian.PutUint64(body[24:], uint64(msg.ProductID))
+	copy(body[32:], msg.RequestID[:])
+	copy(frame[4+bodySize:], sign(secret, body))
+	_, err := w.Write(frame[:])
+	return err
+}
+
+// ReadMessage 读取一帧并校验签名和发送时间
+func ReadMessage(r io.Reader, secret []byte) (*Message, error) {
+	var frame [4 + frameSize]byte
+	if _, err := io.ReadFull(r, frame[:4]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(frame[:4]) != frameSize {
+		return nil, ErrFrame
+	}
+	if _, err := io.ReadFull(r, frame[4:]); err != nil {
+		return nil, err
+	}
+	body := frame[4 : 4+bodySize]
+	if !hmac.Equal(frame[4+bodySize:], sign(secret, body)) {
+		return nil, ErrSignature
+	}
+	if body[0] != Version {
+		return nil, ErrFrame
+	}
+	msg := &Message{
+		Op:        Op(body[1]),
+		Code:      Code(body[2]),
+		Seq:       binary.BigEndian.Uint32(body[4:]),
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(body[8:]))),
+		UserID:    int64(binary.BigEndian.Uint64(body[16:])),
+		ProductID: int64(binary.BigEndian.Uint64(body[24:])),
+	}
+	copy(msg.RequestID[:], body[32:])
+	if skew := time.Since(msg.Time); skew > MaxSkew || skew < -MaxSkew {
+		return nil, ErrStale
+	}
+	return msg, nil
+}
+
+// sign 计算消息体签名
+func sign(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)[:macSize]
+}
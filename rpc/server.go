@@ -0,0 +1,190 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// idleTimeout 连接空闲超时时间，客户端连接池中的连接超时后重新建立
+const idleTimeout = 5 * time.Minute
+
+// Handler 请求处理函数，返回处理结果
+type Handler func(ctx context.Context, msg *Message) Code
+
+// Server 内部协议服务端
+// 每个连接上的请求按顺序处理，客户端通过多个连接并发请求
+type Server struct {
+	secret   []byte
+	handlers map[Op]Handler
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	// 最近收到的请求，拒绝重放
+	replay *replayCache
+	sync.Mutex
+}
+
+// NewServer 创建服务端，secret 为集群密钥
+func NewServer(secret []byte) *Server {
+	return &Server{
+		secret:   secret,
+		handlers: make(map[Op]Handler),
+		conns:    make(map[net.Conn]struct{}),
+		replay:   newReplayCache(),
+	}
+}
+
+// Handle 注册操作的处理函数，需在 Serve 之前调用
+func (s *Server) Handle(op Op, handler Handler) {
+	s.handlers[op] = handler
+}
+
+// ListenAndServe 监听地址并处理请求
+func (s *Server) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve 在监听器上处理请求，Close 后返回 nil
+func (s *Server) Serve(listener net.Listener) error {
+	s.Lock()
+	s.listener = listener
+	s.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return nil
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		s.Lock()
+		s.conns[conn] = struct{}{}
+		s.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close 停止监听并关闭所有连接
+func (s *Server) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// serveConn 处理一个连接上的请求，签名错误、过期或重放的请求断开连接
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		msg, err := ReadMessage(reader, s.secret)
+		if err == nil && !s.replay.add(msg) {
+			err = ErrStale
+		}
+		if err != nil {
+			if errors.Is(err, ErrSignature) || errors.Is(err, ErrFrame) || errors.Is(err, ErrStale) {
+				fmt.Println("rpc 请求校验失败:", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		reply := &Message{
+			Op:        msg.Op | opReply,
+			Code:      CodeError,
+			Seq:       msg.Seq,
+			UserID:    msg.UserID,
+			ProductID: msg.ProductID,
+			RequestID: msg.RequestID,
+		}
+		if handler, ok := s.handlers[msg.Op]; ok {
+			reply.Code = handler(context.Background(), msg)
+		}
+		if err = WriteMessage(conn, s.secret, reply); err != nil {
+			return
+		}
+	}
+}
+
+// replayKey 标识一个请求，发送时间精确到纳秒，不同请求不会相同
+type replayKey struct {
+	time      int64
+	seq       uint32
+	op        Op
+	userID    int64
+	productID int64
+}
+
+// replayCache 记录 MaxSkew 内收到的请求，
+// 更早的请求已被 ReadMessage 拒绝，不需要保留
+type replayCache struct {
+	seen map[replayKey]time.Time
+	// 上次清理时间
+	purged time.Time
+	sync.Mutex
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{
+		seen:   make(map[replayKey]time.Time),
+		purged: time.Now(),
+	}
+}
+
+// add 记录请求，请求已经收到过时返回 false
+func (c *replayCache) add(msg *Message) bool {
+	key := replayKey{
+		time:      msg.Time.UnixNano(),
+		seq:       msg.Seq,
+		op:        msg.Op,
+		userID:    msg.UserID,
+		productID: msg.ProductID,
+	}
+	now := time.Now()
+
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = msg.Time
+	if now.Sub(c.purged) > MaxSkew {
+		for k, t := range c.seen {
+			if now.Sub(t) > MaxSkew {
+				delete(c.seen, k)
+			}
+		}
+		c.purged = now
+	}
+	return true
+}
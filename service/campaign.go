@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"litemall/model"
+	"litemall/repository"
+)
+
+var (
+	// ErrCampaignNotFound 商品没有秒杀活动
+	ErrCampaignNotFound = errors.New("商品没有秒杀活动")
+	// ErrCampaignNotStarted 秒杀活动尚未开始
+	ErrCampaignNotStarted = errors.New("秒杀活动尚未开始")
+	// ErrCampaignEnded 秒杀活动已结束
+	ErrCampaignEnded = errors.New("秒杀活动已结束")
+)
+
+// campaignCacheTTL 秒杀接口查询活动信息的缓存时间
+const campaignCacheTTL = 5 * time.Second
+
+// ICampaignService 对于秒杀活动服务的接口
+type ICampaignService interface {
+	GetCampaignByID(int64) (*model.Campaign, error)
+	GetAllCampaign() ([]*model.Campaign, error)
+	DeleteCampaignByID(int64) bool
+	InsertCampaign(*model.Campaign) (int64, error)
+	UpdateCampaign(*model.Campaign) error
+	CheckCampaign(int64, time.Time) (*model.Campaign, error)
+}
+
+// campaignCache 商品秒杀活动缓存
+type campaignCache struct {
+	campaigns []*model.Campaign
+	expireAt  time.Time
+}
+
+// CampaignService 秒杀活动服务实例
+type CampaignService struct {
+	campaignRepository repository.ICampaign
+	// 商品是否必须有秒杀活动才能抢购
+	required bool
+	// key 为商品 ID
+	cache map[int64]*campaignCache
+	sync.RWMutex
+}
+
+// NewCampaignService 新建服务实例，没有秒杀活动的商品不能抢购
+func NewCampaignService(repository repository.ICampaign) ICampaignService {
+	return NewCampaignServiceWithRequired(repository, true)
+}
+
+// NewCampaignServiceWithRequired 新建服务实例
+// required 为 false 时没有秒杀活动的商品不受活动限制，CheckCampaign 返回 nil, nil
+func NewCampaignServiceWithRequired(repository repository.ICampaign, required bool) ICampaignService {
+	return &CampaignService{
+		campaignRepository: repository,
+		required:           required,
+		cache:              make(map[int64]*campaignCache),
+	}
+}
+
+// GetCampaignByID 根据 ID 查询秒杀活动
+func (c *CampaignService) GetCampaignByID(id int64) (*model.Campaign, error) {
+	return c.campaignRepository.SelectByKey(id)
+}
+
+// GetAllCampaign 查询所有秒杀活动
+func (c *CampaignService) GetAllCampaign() ([]*model.Campaign, error) {
+	return c.campaignRepository.SelectAll()
+}
+
+// DeleteCampaignByID 通过 ID 删除秒杀活动
+func (c *CampaignService) DeleteCampaignByID(id int64) bool {
+	c.clearCache()
+	return c.campaignRepository.Delete(id)
+}
+
+// InsertCampaign 插入秒杀活动
+func (c *CampaignService) InsertCampaign(campaign *model.Campaign) (int64, error) {
+	if err := validateCampaign(campaign); err != nil {
+		return 0, err
+	}
+	c.clearCache()
+	return c.campaignRepository.Insert(campaign)
+}
+
+// UpdateCampaign 更新秒杀活动
+func (c *CampaignService) UpdateCampaign(campaign *model.Campaign) error {
+	if err := validateCampaign(campaign); err != nil {
+		return err
+	}
+	c.clearCache()
+	return c.campaignRepository.Update(campaign)
+}
+
+// CheckCampaign 校验商品在指定时间是否处于秒杀活动中
+// 返回进行中的活动，否则返回对应的原因；
+// 商品没有秒杀活动且不要求活动时返回 nil, nil
+func (c *CampaignService) CheckCampaign(productID int64, now time.Time) (*model.Campaign, error) {
+	campaigns, err := c.getCampaignsByProductID(productID, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(campaigns) == 0 {
+		if !c.required {
+			return nil, nil
+		}
+		return nil, ErrCampaignNotFound
+	}
+
+	reason := ErrCampaignEnded
+	for _, campaign := range campaigns {
+		switch campaign.Status(now) {
+		case model.CampaignActive:
+			return campaign, nil
+		case model.CampaignPending:
+			reason = ErrCampaignNotStarted
+		}
+	}
+	return nil, reason
+}
+
+// getCampaignsByProductID 查询商品的秒杀活动，优先读取缓存
+func (c *CampaignService) getCampaignsByProductID(productID int64, now time.Time) ([]*model.Campaign, error) {
+	c.RLock()
+	cache, ok := c.cache[productID]
+	c.RUnlock()
+	if ok && now.Before(cache.expireAt) {
+		return cache.campaigns, nil
+	}
+
+	campaigns, err := c.campaignRepository.SelectByProductID(productID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	c.cache[productID] = &campaignCache{
+		campaigns: campaigns,
+		expireAt:  now.Add(campaignCacheTTL),
+	}
+	c.Unlock()
+	return campaigns, nil
+}
+
+// clearCache 清空缓存
+func (c *CampaignService) clearCache() {
+	c.Lock()
+	c.cache = make(map[int64]*campaignCache)
+	c.Unlock()
+}
+
+// validateCampaign 校验活动信息
+func validateCampaign(campaign *model.Campaign) error {
+	if campaign.ProductID <= 0 {
+		return errors.New("商品 ID 错误！")
+	}
+	if !campaign.EndTime.After(campaign.StartTime) {
+		return errors.New("结束时间必须晚于开始时间！")
+	}
+	// 为 0 时不限制
+	if campaign.Number < 0 || campaign.UserLimit < 0 {
+		return errors.New("数量不能为负数！")
+	}
+	return nil
+}
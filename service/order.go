@@ -1,6 +1,8 @@
 package service
 
 import (
+	"errors"
+
 	"litemall/model"
 	"litemall/repository"
 )
@@ -14,6 +16,7 @@ type IOrderService interface {
 	InsertOrder(*model.Order) (int64, error)
 	UpdateOrder(*model.Order) error
 	InsertOrderByMessage(*model.Message) (int64, error)
+	InsertOrdersByMessage([]*model.Message) ([]int64, error)
 }
 
 // OrderService 订单服务实例
@@ -58,12 +61,32 @@ func (o *OrderService) UpdateOrder(order *model.Order) error {
 	return o.OrderRepository.Update(order)
 }
 
-// InsertOrderByMessage 根据消息创建订单
+// InsertOrderByMessage 根据消息创建订单并扣除商品库存
+// 重复投递的消息返回已有订单，不会重复创建订单和扣除库存
 func (o *OrderService) InsertOrderByMessage(message *model.Message) (orderID int64, err error) {
-	order := &model.Order{
+	orderID, err = o.OrderRepository.InsertWithStock(newOrderByMessage(message))
+	if errors.Is(err, repository.ErrOrderExists) {
+		return orderID, nil
+	}
+	return
+}
+
+// InsertOrdersByMessage 在同一事务中根据多条消息创建订单并扣除商品库存
+// 重复投递的消息返回已有订单，任意一条失败时全部不生效
+func (o *OrderService) InsertOrdersByMessage(messages []*model.Message) ([]int64, error) {
+	orders := make([]*model.Order, 0, len(messages))
+	for _, message := range messages {
+		orders = append(orders, newOrderByMessage(message))
+	}
+	return o.OrderRepository.InsertBatchWithStock(orders)
+}
+
+// newOrderByMessage 根据消息生成秒杀成功的订单
+func newOrderByMessage(message *model.Message) *model.Order {
+	return &model.Order{
 		UserID:    message.UserID,
 		ProductID: message.ProductID,
 		Status:    model.OrderSuccess,
+		RequestID: message.RequestID,
 	}
-	return o.InsertOrder(order)
 }
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"litemall/model"
+	"litemall/repository"
+)
+
+// IOutboxService 对于事件发件箱服务的接口
+type IOutboxService interface {
+	GetPendingEvents(int) ([]*model.Outbox, error)
+	MarkEventSent(int64) error
+}
+
+// OutboxService 事件发件箱服务实例
+type OutboxService struct {
+	outboxRepository repository.IOutbox
+}
+
+// NewOutboxService 新建服务实例
+func NewOutboxService(repository repository.IOutbox) IOutboxService {
+	return &OutboxService{
+		outboxRepository: repository,
+	}
+}
+
+// GetPendingEvents 按写入顺序查询最早的待发布事件
+func (o *OutboxService) GetPendingEvents(limit int) ([]*model.Outbox, error) {
+	return o.outboxRepository.SelectPending(limit)
+}
+
+// MarkEventSent 标记事件已发布
+func (o *OutboxService) MarkEventSent(id int64) error {
+	return o.outboxRepository.MarkSent(id)
+}
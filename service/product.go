@@ -14,6 +14,7 @@ type IProductService interface {
 	InsertProduct(*model.Product) (int64, error)
 	UpdateProduct(*model.Product) error
 	SubNumberOne(int64) error
+	DecrementStock(int64, int64) error
 }
 
 // ProductService 商品服务实例
@@ -57,3 +58,8 @@ func (p *ProductService) UpdateProduct(product *model.Product) error {
 func (p *ProductService) SubNumberOne(productID int64) error {
 	return p.productRepository.SubProductNum(productID)
 }
+
+// DecrementStock 扣除商品库存，库存不足时返回 repository.ErrSoldOut
+func (p *ProductService) DecrementStock(productID, qty int64) error {
+	return p.productRepository.DecrementStock(productID, qty)
+}
@@ -0,0 +1,76 @@
+package token
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheEntry 缓存的注销状态
+type cacheEntry struct {
+	revoked bool
+	// 缓存过期时间
+	expireAt time.Time
+}
+
+// CachedRevoker 在注销列表前加一层短期缓存
+// 每次校验令牌都查询数据库代价较高，缓存期内不再查询，
+// 其它实例注销的令牌最多在 ttl 后失效，本实例注销的令牌立即失效
+type CachedRevoker struct {
+	revoker Revoker
+	ttl     time.Duration
+	// key 为令牌 ID
+	entries   map[string]cacheEntry
+	lastSweep time.Time
+	sync.Mutex
+}
+
+// NewCachedRevoker 创建带缓存的注销列表，ttl 为缓存时间
+func NewCachedRevoker(revoker Revoker, ttl time.Duration) *CachedRevoker {
+	return &CachedRevoker{
+		revoker:   revoker,
+		ttl:       ttl,
+		entries:   make(map[string]cacheEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Revoke 注销令牌，同时更新缓存
+func (r *CachedRevoker) Revoke(id string, expireAt time.Time) error {
+	if err := r.revoker.Revoke(id, expireAt); err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	// 已注销的状态不会改变，缓存到令牌过期
+	r.entries[id] = cacheEntry{revoked: true, expireAt: expireAt}
+	return nil
+}
+
+// IsRevoked 判断令牌是否已注销，缓存未命中时查询注销列表
+func (r *CachedRevoker) IsRevoked(id string) (bool, error) {
+	now := time.Now()
+	r.Lock()
+	entry, ok := r.entries[id]
+	r.Unlock()
+	if ok && now.Before(entry.expireAt) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := r.revoker.IsRevoked(id)
+	if err != nil {
+		return false, err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.entries[id] = cacheEntry{revoked: revoked, expireAt: now.Add(r.ttl)}
+	// 定期清理过期的缓存，防止内存无限增长
+	if now.Sub(r.lastSweep) > r.ttl {
+		for k, v := range r.entries {
+			if now.After(v.expireAt) {
+				delete(r.entries, k)
+			}
+		}
+		r.lastSweep = now
+	}
+	return revoked, nil
+}
@@ -0,0 +1,152 @@
+// Package token 签名会话令牌
+// 令牌由载荷和 HMAC-SHA256 签名两部分组成，均为 base64url 编码，以 "." 连接
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMalformed 令牌格式错误
+	ErrMalformed = errors.New("令牌格式错误")
+	// ErrSignature 令牌签名错误
+	ErrSignature = errors.New("令牌签名错误")
+	// ErrExpired 令牌已过期
+	ErrExpired = errors.New("令牌已过期")
+	// ErrRevoked 令牌已注销
+	ErrRevoked = errors.New("令牌已注销")
+)
+
+// Claims 令牌载荷
+type Claims struct {
+	// 用户 ID
+	UserID int64 `json:"uid"`
+	// 签发时间，Unix 秒
+	IssuedAt int64 `json:"iat"`
+	// 过期时间，Unix 秒
+	ExpireAt int64 `json:"exp"`
+	// 令牌 ID，用于注销
+	ID string `json:"jti"`
+}
+
+// Revoker 令牌注销列表
+type Revoker interface {
+	// Revoke 注销令牌，令牌过期后记录可以清除
+	Revoke(id string, expireAt time.Time) error
+	// IsRevoked 判断令牌是否已注销
+	IsRevoked(id string) (bool, error)
+}
+
+// Manager 令牌签发与校验
+type Manager struct {
+	secret  []byte
+	ttl     time.Duration
+	revoker Revoker
+}
+
+// NewManager 创建令牌管理器，revoker 为 nil 时不支持注销
+func NewManager(secret []byte, ttl time.Duration, revoker Revoker) *Manager {
+	return &Manager{
+		secret:  secret,
+		ttl:     ttl,
+		revoker: revoker,
+	}
+}
+
+// Issue 为用户签发令牌
+func (m *Manager) Issue(userID int64) (string, *Claims, error) {
+	id, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &Claims{
+		UserID:   userID,
+		IssuedAt: now.Unix(),
+		ExpireAt: now.Add(m.ttl).Unix(),
+		ID:       id,
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + m.sign(encoded), claims, nil
+}
+
+// Verify 校验令牌签名、有效期及是否注销，返回载荷
+func (m *Manager) Verify(token string) (*Claims, error) {
+	claims, err := m.parse(token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= claims.ExpireAt {
+		return nil, ErrExpired
+	}
+	if m.revoker != nil {
+		revoked, err := m.revoker.IsRevoked(claims.ID)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrRevoked
+		}
+	}
+	return claims, nil
+}
+
+// Revoke 注销令牌，用于退出登录
+func (m *Manager) Revoke(token string) error {
+	if m.revoker == nil {
+		return errors.New("未配置令牌注销列表")
+	}
+	claims, err := m.parse(token)
+	if err != nil {
+		return err
+	}
+	return m.revoker.Revoke(claims.ID, time.Unix(claims.ExpireAt, 0))
+}
+
+// parse 校验签名并解析载荷
+func (m *Manager) parse(token string) (*Claims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal([]byte(signature), []byte(m.sign(encoded))) {
+		return nil, ErrSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrMalformed
+	}
+	return claims, nil
+}
+
+// sign 计算签名
+func (m *Manager) sign(encoded string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newTokenID 生成随机令牌 ID
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -3,6 +3,7 @@ package tool
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/kataras/iris/v12"
 )
@@ -11,3 +12,13 @@ import (
 func GlobalCookie(ctx iris.Context, name, value string) {
 	ctx.SetCookie(&http.Cookie{Name: name, Value: value, Path: "/"})
 }
+
+// GlobalHTTPOnlyCookie 设置带过期时间的全局cookie，脚本不可读取
+func GlobalHTTPOnlyCookie(ctx iris.Context, name, value string, expires time.Time) {
+	ctx.SetCookie(&http.Cookie{Name: name, Value: value, Path: "/", Expires: expires, HttpOnly: true})
+}
+
+// RemoveGlobalCookie 删除全局cookie
+func RemoveGlobalCookie(ctx iris.Context, name string) {
+	ctx.SetCookie(&http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1})
+}
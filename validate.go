@@ -2,152 +2,389 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
+	"litemall/cluster"
 	"litemall/common"
-	"litemall/encrypt"
+	"litemall/config"
 	"litemall/model"
 	"litemall/rabbitmq"
+	"litemall/repository"
+	"litemall/rpc"
+	"litemall/service"
+	"litemall/token"
 )
 
+// 以下地址均由配置设置
 var (
-	hosts     = []string{"http://127.0.0.1", "http://127.0.0.1"}
-	localhost = "http://127.0.0.1"
-	port      = "8081"
+	// 种子节点内网IP，hash环成员由集群心跳维护
+	hosts     []string
+	localhost string
+	port      string
 	// GetOneIP 数量控制接口服务器内网IP，或者getone的SLB内网IP
-	GetOneIP = "127.0.0.1"
+	GetOneIP string
 	// GetOnePort 对应端口
-	GetOnePort       = "8084"
-	hashConsistent   *common.Consistent
-	rabbitMQValidate *rabbitmq.RabbitMQ
+	GetOnePort     string
+	hashConsistent *common.Consistent
+	peerHealth     *cluster.Health
+	// 节点之间请求使用的客户端
+	peerClient *cluster.PeerClient
+	// 归属节点不可达时最多再尝试的节点数
+	failover int
+	// 内部协议端口，集群内各节点相同
+	rpcPort string
+	// GetOneRPCPort 数量控制服务内部协议端口
+	GetOneRPCPort string
+	// 内部协议客户端，为 nil 时节点之间使用 HTTP
+	rpcPool *rpc.Pool
+	// 订单消息发布者
+	rabbitMQValidate rabbitmq.Publisher
+	campaignService  service.ICampaignService
+	tokenManager     *token.Manager
 	accessControl    = &AccessControl{
-		sourcesArray: make(map[int]interface{}),
+		sourcesArray: make(map[int]map[int64]int64),
 	}
+	// 订单事件发布者，为 nil 时不发布
+	orderEvents rabbitmq.Publisher
 )
 
 // AccessControl 访问控制
+// 记录一致性hash环上归属本机的用户对各商品的购买数量
 type AccessControl struct {
-	// 用来存放用户想要存放的信息
-	sourcesArray map[int]interface{}
+	// key 为用户 ID，值为各商品的购买数量
+	sourcesArray map[int]map[int64]int64
 	sync.RWMutex
 }
 
-// GetNewRecord 获取制定的数据
-func (m *AccessControl) GetNewRecord(uid int) interface{} {
+// GetNewRecord 获取用户对指定商品的购买数量
+func (m *AccessControl) GetNewRecord(uid int, productID int64) int64 {
 	m.RWMutex.RLock()
 	defer m.RWMutex.RUnlock()
-	data := m.sourcesArray[uid]
-	return data
+	return m.sourcesArray[uid][productID]
 }
 
-// SetNewRecord 设置记录
-func (m *AccessControl) SetNewRecord(uid int) {
+// SetNewRecord 记录一次购买
+// limit 为每人限购数量，为 0 时不限购，超过限购返回 false
+func (m *AccessControl) SetNewRecord(uid int, productID int64, limit int64) bool {
 	m.RWMutex.Lock()
-	m.sourcesArray[uid] = "hello litemall"
-	m.RWMutex.Unlock()
+	defer m.RWMutex.Unlock()
+	records, ok := m.sourcesArray[uid]
+	if !ok {
+		records = make(map[int64]int64)
+		m.sourcesArray[uid] = records
+	}
+	if limit > 0 && records[productID] >= limit {
+		return false
+	}
+	records[productID]++
+	return true
+}
+
+// DeleteRecord 撤销一次购买记录，后续下单失败时调用
+func (m *AccessControl) DeleteRecord(uid int, productID int64) {
+	m.RWMutex.Lock()
+	defer m.RWMutex.Unlock()
+	records, ok := m.sourcesArray[uid]
+	if !ok || records[productID] <= 0 {
+		return
+	}
+	records[productID]--
+	if records[productID] == 0 {
+		delete(records, productID)
+	}
+	if len(records) == 0 {
+		delete(m.sourcesArray, uid)
+	}
 }
 
 // GetDistributedRight 得到顺时针分配的
-func (m *AccessControl) GetDistributedRight(req *http.Request) bool {
+// 校验通过时在归属节点上记录一次购买，
+// 归属节点不可达时标记为可疑，转到hash环上的下一个节点，
+// 请求已发出但没有收到结果时撤销该节点上的记录并拒绝
+// 返回实际处理的节点，撤销购买记录时使用
+// 请求各节点期间计入该节点的负载，有界负载模式据此分散热点用户
+func (m *AccessControl) GetDistributedRight(req *http.Request) (string, bool) {
 	// 获取用户UID
 	uid, err := req.Cookie("uid")
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	// 采用一致性hash算法，根据用户ID，判断获取具体机器
-	hostRequest, err := hashConsistent.Get(uid.Value)
+	hosts, err := candidateHosts(uid.Value)
 	if err != nil {
-		return false
+		return "", false
+	}
+	for _, hostRequest := range hosts {
+		// 判断是否为本机
+		if hostRequest == localhost {
+			// 执行本机数据读取和校验
+			hashConsistent.Inc(hostRequest)
+			right := m.GetDataFromMap(uid.Value, req.URL.Query().Get("productID"))
+			hashConsistent.Done(hostRequest)
+			return hostRequest, right
+		}
+		// 不是本机充当代理访问数据返回结果
+		hashConsistent.Inc(hostRequest)
+		right, err := GetDataFromOtherMap(hostRequest, "/checkRight", req)
+		hashConsistent.Done(hostRequest)
+		if err == nil {
+			return hostRequest, right
+		}
+		// 调用方已取消或请求已发出，节点可能已经记录购买，撤销后拒绝，不能转到下一个节点
+		if req.Context().Err() != nil || !isPeerDown(err) {
+			fmt.Println("节点处理结果未知，撤销购买记录:", hostRequest, err)
+			m.ReleaseDistributedRight(hostRequest, req)
+			return "", false
+		}
+		peerHealth.Suspect(hostRequest)
+		fmt.Println("节点不可达，转到下一个节点:", hostRequest, err)
+	}
+	return "", false
+}
+
+// candidateHosts 按顺序获取用户可以分配的节点，跳过可疑节点
+// 首个节点为hash环分配的节点，其后为顺时针的后续节点
+// 每人限购在处理请求的节点内计数，未启用有界负载时同一用户固定分配到归属节点，
+// 启用后归属节点负载过高时首个节点为下一个未超限的节点
+func candidateHosts(uid string) ([]string, error) {
+	owner, err := hashConsistent.Get(uid)
+	if err != nil {
+		return nil, err
+	}
+	replicas, err := hashConsistent.GetN(uid, failover+1)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]string, 0, len(replicas)+1)
+	for _, host := range append([]string{owner}, replicas...) {
+		if host != localhost && !peerHealth.Healthy(host) {
+			continue
+		}
+		if !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
 	}
+	return hosts, nil
+}
 
-	// 判断是否为本机
+// isPeerDown 判断是否为节点不可达，建立连接超时或被拒绝，此时请求还没有发出
+// 请求发出后的超时和连接重置无法确定节点是否已处理
+func isPeerDown(err error) bool {
+	return cluster.IsDialError(err)
+}
+
+// ReleaseDistributedRight 撤销归属节点上的购买记录
+// hostRequest 必须与记录购买时的节点相同，节点不可达时放弃
+func (m *AccessControl) ReleaseDistributedRight(hostRequest string, req *http.Request) {
+	uid, err := req.Cookie("uid")
+	if err != nil {
+		return
+	}
 	if hostRequest == localhost {
-		// 执行本机数据读取和校验
-		return m.GetDataFromMap(uid.Value)
+		m.ReleaseDataFromMap(uid.Value, req.URL.Query().Get("productID"))
+		return
 	}
-	// 不是本机充当代理访问数据返回结果
-	return GetDataFromOtherMap(hostRequest, req)
+	// 用户断开连接时仍需撤销
+	GetDataFromOtherMap(hostRequest, "/releaseRight", req.WithContext(context.WithoutCancel(req.Context())))
 }
 
 // GetDataFromMap 获取本机map
 // 并且处理业务逻辑
 // 返回的结果类型为bool类型
-func (m *AccessControl) GetDataFromMap(uid string) bool {
+func (m *AccessControl) GetDataFromMap(uid string, productString string) bool {
 	uidInt, err := strconv.Atoi(uid)
 	if err != nil {
 		return false
 	}
-	data := m.GetNewRecord(uidInt)
-	// 执行逻辑判断
-	// 测试使用
-	if data != nil {
-		return true
+	productID, err := strconv.ParseInt(productString, 10, 64)
+	if err != nil {
+		return false
 	}
-	return true
+	return m.GetRight(uidInt, productID)
 }
 
-// GetDataFromOtherMap 获取其它节点处理结果
-func GetDataFromOtherMap(host string, request *http.Request) bool {
-	hostURL := "http://" + host + ":" + port + "/checkRight"
-	response, body, err := GetCurl(hostURL, request)
+// GetRight 在本机记录一次购买，超出活动的每人限购数量时返回 false
+func (m *AccessControl) GetRight(uid int, productID int64) bool {
+	// 获取活动的每人限购数量，没有活动时不限购
+	campaign, err := campaignService.CheckCampaign(productID, time.Now())
 	if err != nil {
 		return false
 	}
-	// 判断状态
-	if response.StatusCode == 200 {
-		return string(body) == "true"
+	var limit int64
+	if campaign != nil {
+		limit = campaign.UserLimit
 	}
-	return false
+	return m.SetNewRecord(uid, productID, limit)
 }
 
-// GetCurl 模拟请求
-func GetCurl(hostURL string, request *http.Request) (response *http.Response, body []byte, err error) {
-	// 获取Uid
-	uidPre, err := request.Cookie("uid")
+// ReleaseDataFromMap 撤销本机的购买记录
+func (m *AccessControl) ReleaseDataFromMap(uid string, productString string) {
+	uidInt, err := strconv.Atoi(uid)
 	if err != nil {
 		return
 	}
-	// 获取sign
-	uidSign, err := request.Cookie("sign")
+	productID, err := strconv.ParseInt(productString, 10, 64)
 	if err != nil {
 		return
 	}
+	m.DeleteRecord(uidInt, productID)
+}
 
-	// 模拟接口访问，
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", hostURL, nil)
+// GetDataFromOtherMap 获取其它节点处理结果
+// 请求失败时返回错误，由调用方判断节点是否不可达
+func GetDataFromOtherMap(host string, path string, request *http.Request) (bool, error) {
+	if rpcPool != nil {
+		return GetDataFromOtherRPC(host, rpcOps[path], request)
+	}
+	userID, productID, err := parseRightRequest(request)
+	if err != nil {
+		return false, err
+	}
+	hostURL := "http://" + host + ":" + port + path + "?" + rightQuery(userID, productID).Encode()
+	status, body, err := GetCurl(hostURL, request)
+	if err != nil {
+		return false, err
+	}
+	// 判断状态
+	if status == 200 {
+		return string(body) == "true", nil
+	}
+	return false, nil
+}
+
+// rpcOps 节点接口对应的内部协议操作
+var rpcOps = map[string]rpc.Op{
+	"/checkRight":   rpc.OpCheckRight,
+	"/releaseRight": rpc.OpReleaseRight,
+}
+
+// GetDataFromOtherRPC 通过内部协议获取其它节点处理结果
+// 用户身份已由本机验证，只传递用户 ID
+func GetDataFromOtherRPC(host string, op rpc.Op, request *http.Request) (bool, error) {
+	userID, productID, err := parseRightRequest(request)
+	if err != nil {
+		return false, err
+	}
+	client := rpcPool.Client(net.JoinHostPort(host, rpcPort))
+	code, err := client.Call(request.Context(), op, userID, productID, rpc.RequestID{})
 	if err != nil {
+		return false, err
+	}
+	return code == rpc.CodeOK, nil
+}
+
+// GetOneRight 获取数量控制权限，requestID 为订单的请求 ID，归还时使用
+// 活动时间外返回拒绝原因 reason
+func GetOneRight(request *http.Request, productID int64, requestID string) (right bool, reason error, err error) {
+	userID, _, err := parseRightRequest(request)
+	if err != nil {
+		return false, nil, err
+	}
+	if rpcPool != nil {
+		id, err := rpc.ParseRequestID(requestID)
+		if err != nil {
+			return false, nil, err
+		}
+		client := rpcPool.Client(net.JoinHostPort(GetOneIP, GetOneRPCPort))
+		code, err := client.Call(request.Context(), rpc.OpGetOne, userID, productID, id)
+		if err != nil {
+			return false, nil, err
+		}
+		return code == rpc.CodeOK, code.Reason(), nil
+	}
+
+	query := rightQuery(userID, productID)
+	query.Set("requestID", requestID)
+	hostURL := "http://" + GetOneIP + ":" + GetOnePort + "/getOne?" + query.Encode()
+	status, body, err := GetCurl(hostURL, request)
+	if err != nil {
+		return false, nil, err
+	}
+	// 数量控制接口拒绝时透传原因
+	if status == http.StatusForbidden {
+		return false, errors.New(string(body)), nil
+	}
+	return status == 200 && string(body) == "true", nil, nil
+}
+
+// ReleaseOneRight 归还请求 ID 对应的数量控制权限
+// 获取权限后下单失败时调用，用户断开连接时仍需归还，
+// 数量控制服务按请求 ID 归还到售出时的活动，没有售出或已经归还时忽略
+func ReleaseOneRight(request *http.Request, productID int64, requestID string) {
+	request = request.WithContext(context.WithoutCancel(request.Context()))
+	userID, _, err := parseRightRequest(request)
+	if err != nil {
+		return
+	}
+	if rpcPool != nil {
+		id, err := rpc.ParseRequestID(requestID)
+		if err != nil {
+			return
+		}
+		client := rpcPool.Client(net.JoinHostPort(GetOneIP, GetOneRPCPort))
+		if _, err = client.Call(request.Context(), rpc.OpReleaseOne, userID, productID, id); err != nil {
+			fmt.Println("归还数量控制权限失败:", productID, err)
+		}
 		return
 	}
 
-	// 手动指定，排查多余cookies
-	cookieUID := &http.Cookie{Name: "uid", Value: uidPre.Value, Path: "/"}
-	cookieSign := &http.Cookie{Name: "sign", Value: uidSign.Value, Path: "/"}
-	// 添加cookie到模拟的请求中
-	req.AddCookie(cookieUID)
-	req.AddCookie(cookieSign)
+	query := rightQuery(userID, productID)
+	query.Set("requestID", requestID)
+	hostURL := "http://" + GetOneIP + ":" + GetOnePort + "/releaseOne?" + query.Encode()
+	if _, _, err := GetCurl(hostURL, request); err != nil {
+		fmt.Println("归还数量控制权限失败:", productID, err)
+	}
+}
 
-	// 获取返回结果
-	response, err = client.Do(req)
-	defer response.Body.Close()
+// parseRightRequest 获取请求中的用户 ID 和商品 ID
+func parseRightRequest(request *http.Request) (userID, productID int64, err error) {
+	uid, err := request.Cookie("uid")
 	if err != nil {
 		return
 	}
-	body, err = io.ReadAll(response.Body)
+	if userID, err = strconv.ParseInt(uid.Value, 10, 64); err != nil {
+		return
+	}
+	productID, err = strconv.ParseInt(request.URL.Query().Get("productID"), 10, 64)
 	return
 }
 
+// rightQuery 节点之间 HTTP 请求的查询参数
+// 用户身份已由本机验证，只传递用户 ID，由集群密钥签名保证不被篡改
+func rightQuery(userID, productID int64) url.Values {
+	return url.Values{
+		"uid":       {strconv.FormatInt(userID, 10)},
+		"productID": {strconv.FormatInt(productID, 10)},
+	}
+}
+
+// GetCurl 模拟请求
+// 使用共享连接池，以集群密钥签名，随用户请求取消，返回状态码和响应内容
+func GetCurl(hostURL string, request *http.Request) (status int, body []byte, err error) {
+	return peerClient.Get(request.Context(), hostURL)
+}
+
 // CheckRight 检测
+// 由其它节点以集群密钥签名调用，本机即为归属节点
 func CheckRight(w http.ResponseWriter, r *http.Request) {
-	right := accessControl.GetDistributedRight(r)
+	query := r.URL.Query()
+	right := accessControl.GetDataFromMap(query.Get("uid"), query.Get("productID"))
 	if !right {
 		w.Write([]byte("false"))
 		return
@@ -156,6 +393,29 @@ func CheckRight(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// ReleaseRight 撤销购买记录
+// 由其它节点以集群密钥签名调用，本机即为归属节点
+func ReleaseRight(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	accessControl.ReleaseDataFromMap(query.Get("uid"), query.Get("productID"))
+	w.Write([]byte("true"))
+}
+
+// RPCCheckRight 内部协议检测
+// 由其它节点调用，本机即为归属节点
+func RPCCheckRight(ctx context.Context, msg *rpc.Message) rpc.Code {
+	if accessControl.GetRight(int(msg.UserID), msg.ProductID) {
+		return rpc.CodeOK
+	}
+	return rpc.CodeDenied
+}
+
+// RPCReleaseRight 内部协议撤销购买记录
+func RPCReleaseRight(ctx context.Context, msg *rpc.Message) rpc.Code {
+	accessControl.DeleteRecord(int(msg.UserID), msg.ProductID)
+	return rpc.CodeOK
+}
+
 // Check 执行正常业务逻辑
 func Check(w http.ResponseWriter, r *http.Request) {
 	// 执行正常业务逻辑
@@ -167,6 +427,12 @@ func Check(w http.ResponseWriter, r *http.Request) {
 	}
 	productString := queryForm["productID"][0]
 	fmt.Println(productString)
+	// 获取商品ID
+	productID, err := strconv.ParseInt(productString, 10, 64)
+	if err != nil {
+		w.Write([]byte("false"))
+		return
+	}
 	// 获取用户cookie
 	userCookie, err := r.Cookie("uid")
 	if err != nil {
@@ -174,111 +440,182 @@ func Check(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 1.分布式权限验证
-	right := accessControl.GetDistributedRight(r)
+	// 校验秒杀活动时间，活动时间外返回具体原因
+	if _, err = campaignService.CheckCampaign(productID, time.Now()); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// 消息队列重连期间无法下单，提前拒绝避免占用购买记录和库存
+	if !rabbitMQValidate.Connected() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("false"))
+		return
+	}
+
+	// 1.分布式权限验证，超出每人限购数量时拒绝
+	hostRequest, right := accessControl.GetDistributedRight(r)
 	if right == false {
 		w.Write([]byte("false"))
 		return
 	}
+	// 后续步骤失败时撤销归属节点上的购买记录，订单消息可能已经入队时不撤销
+	ordered := false
+	defer func() {
+		if !ordered {
+			accessControl.ReleaseDistributedRight(hostRequest, r)
+		}
+	}()
+	// 请求 ID 用于数量控制服务归还和消费端去重
+	requestID, err := common.NewRequestID()
+	if err != nil {
+		w.Write([]byte("false"))
+		return
+	}
 	// 2.获取数量控制权限，防止秒杀出现超卖现象
-	hostURL := "http://" + GetOneIP + ":" + GetOnePort + "/getOne"
-	responseValidate, validateBody, err := GetCurl(hostURL, r)
+	rightValidate, reason, err := GetOneRight(r, productID, requestID)
 	if err != nil {
+		// 无法确定是否已售出，按请求 ID 归还，没有售出时数量控制服务忽略
+		ReleaseOneRight(r, productID, requestID)
 		w.Write([]byte("false"))
 		return
 	}
-	// 判断数量控制接口请求状态
-	if responseValidate.StatusCode == 200 {
-		if string(validateBody) == "true" {
-			// 整合下单
-			// 1.获取商品ID
-			productID, err := strconv.ParseInt(productString, 10, 64)
-			if err != nil {
-
-				w.Write([]byte("false"))
-				return
+	// 数量控制接口拒绝时透传原因
+	if reason != nil {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(reason.Error()))
+		return
+	}
+	// 判断数量控制接口结果
+	if rightValidate {
+		// 后续步骤失败时归还数量控制权限，避免库存少卖
+		defer func() {
+			if !ordered {
+				ReleaseOneRight(r, productID, requestID)
 			}
-			// 2.获取用户ID
-			userID, err := strconv.ParseInt(userCookie.Value, 10, 64)
-			if err != nil {
+		}()
+		// 整合下单
+		// 1.获取用户ID
+		userID, err := strconv.ParseInt(userCookie.Value, 10, 64)
+		if err != nil {
 
-				w.Write([]byte("false"))
-				return
-			}
+			w.Write([]byte("false"))
+			return
+		}
 
-			// 3.创建消息体
-			message := model.NewMessage(userID, productID)
-			// 类型转化
-			byteMessage, err := json.Marshal(message)
-			if err != nil {
-				w.Write([]byte("false"))
-				return
-			}
-			// 4.生产消息
-			err = rabbitMQValidate.PublishSimple(string(byteMessage))
-			if err != nil {
-				w.Write([]byte("false"))
-				return
+		// 2.创建消息体
+		message := model.NewMessage(userID, productID, requestID)
+		// 类型转化
+		byteMessage, err := json.Marshal(message)
+		if err != nil {
+			w.Write([]byte("false"))
+			return
+		}
+		// 3.生产消息
+		// 未确认入队时不能告诉用户抢购成功
+		err = rabbitMQValidate.Publish(byteMessage)
+		if err != nil {
+			// 确认超时等情况下消息可能已经入队，撤销会导致超卖，保留购买记录和库存，
+			// 消息入队时由消费端按请求 ID 去重创建订单，没有入队时该件商品少卖
+			if !rabbitmq.NotEnqueued(err) {
+				ordered = true
+				fmt.Println("订单消息发布结果未知，保留购买记录和库存:", requestID, err)
+			} else {
+				fmt.Println("订单消息发送失败:", err)
 			}
-			w.Write([]byte("true"))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("false"))
 			return
 		}
+		ordered = true
+		// 4.广播订单事件，不影响抢购结果
+		if orderEvents != nil {
+			go publishOrderEvent(model.NewOrderEvent(model.OrderPlaced, message))
+		}
+		w.Write([]byte("true"))
+		return
 	}
 	w.Write([]byte("false"))
 	return
 }
 
-// Auth 统一验证拦截器
-// 每个接口都需要验证
-func Auth(w http.ResponseWriter, r *http.Request) error {
-	fmt.Println("执行验证！")
-	// 添加基于cookie的权限验证
-	err := CheckUserInfo(r)
+// publishOrderEvent 发布订单事件，失败时只记录日志
+func publishOrderEvent(event *model.OrderEvent) {
+	body, err := json.Marshal(event)
 	if err != nil {
-		return err
+		fmt.Println(err)
+		return
+	}
+	if err = orderEvents.Publish(body); err != nil {
+		fmt.Println("订单事件发送失败:", event.RequestID, err)
 	}
-	return nil
 }
 
-// CheckUserInfo 验证用户信息
-func CheckUserInfo(r *http.Request) error {
+// NewUserAuth 创建用户请求的验证拦截器
+// 验证通过后按令牌中的用户 ID 限流，超限返回 429
+func NewUserAuth(userLimiter *common.RateLimiter) common.FilterHandle {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		claims, err := CheckUserInfo(r)
+		if err != nil {
+			return err
+		}
+		if !userLimiter.Allow(strconv.FormatInt(claims.UserID, 10)) {
+			return common.TooManyRequests(w)
+		}
+		return nil
+	}
+}
+
+// CheckUserInfo 验证用户信息，返回令牌中的用户信息
+func CheckUserInfo(r *http.Request) (*token.Claims, error) {
 	// 获取 cookie
 	uid, err := r.Cookie("uid")
 	if err != nil {
-		return errors.New("用户 uid 获取失败")
+		return nil, errors.New("用户 uid 获取失败")
 	}
 
-	// 获取用户加密串
-	sign, err := r.Cookie("sign")
+	// 获取会话令牌
+	tokenCookie, err := r.Cookie("token")
 	if err != nil {
-		return errors.New("用户加密串获取失败")
+		return nil, errors.New("用户令牌获取失败")
 	}
 
-	// 对信息进行解密
-	signByte, err := encrypt.DePasswordCode(sign.Value)
+	// 校验令牌签名、有效期及注销状态
+	claims, err := tokenManager.Verify(tokenCookie.Value)
 	if err != nil {
-		return errors.New("用户加密串已被篡改")
+		return nil, err
 	}
 
-	if checkInfo(uid.Value, string(signByte)) {
-		return nil
+	if checkInfo(uid.Value, strconv.FormatInt(claims.UserID, 10)) {
+		return claims, nil
 	}
-	return errors.New("身份校验失败")
+	return nil, errors.New("身份校验失败")
 }
 
 // checkInfo 自定义逻辑判断
-func checkInfo(checkStr, signStr string) bool {
-	return checkStr == signStr
+func checkInfo(checkStr, tokenStr string) bool {
+	return checkStr == tokenStr
 }
 
 func main() {
-	// 负载均衡器设置
-	// 使用一致性哈希算法
-	hashConsistent = common.NewConsistent()
-	// 添加节点
-	for _, v := range hosts {
-		hashConsistent.Add(v)
+	// 加载配置
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 校验用户令牌，节点之间及数量控制服务使用集群密钥
+	if err = errors.Join(cfg.CheckToken(), cfg.CheckCluster()); err != nil {
+		log.Fatal(err)
 	}
+	common.SetMySQLDSN(cfg.MySQL.DSN)
+	rabbitmq.MQURL = cfg.RabbitMQ.URL
+	hosts = cfg.Validate.Hosts
+	port = cfg.Validate.Port
+	GetOneIP = cfg.Validate.GetOneHost
+	GetOnePort = cfg.Validate.GetOnePort
+	rpcPort = cfg.Validate.RPCPort
+	GetOneRPCPort = cfg.Validate.GetOneRPCPort
 
 	localIP, err := common.GetIntranceIP()
 	if err != nil {
@@ -288,17 +625,112 @@ func main() {
 	localhost = localIP
 	fmt.Println(localhost)
 
-	rabbitMQValidate = rabbitmq.NewRabbitMQSimple("imoocProduct")
-	defer rabbitMQValidate.Destroy()
+	// 负载均衡器设置
+	// 使用一致性哈希算法，节点通过心跳自动加入和移出hash环
+	// 配置中的节点作为种子节点
+	hasher, err := common.NewHasher(cfg.Validate.Hash)
+	if err != nil {
+		log.Fatal(err)
+	}
+	hashConsistent = common.NewConsistentWithHasher(hasher)
+	// 有界负载模式下热点用户可能分配到其它节点，默认不启用
+	if err = hashConsistent.SetLoadFactor(cfg.Validate.LoadFactor); err != nil {
+		log.Fatal(err)
+	}
+	// 权重决定本机分到的用户比例，配置较高的机器可以调大
+	membership, err := cluster.NewMembership(localhost, cfg.Validate.Weight, port, hosts, hashConsistent,
+		cfg.Validate.HeartbeatInterval, cfg.Validate.HeartbeatTimeout, []byte(cfg.Cluster.Secret))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// 节点不可达时转到下一个节点，可疑节点连续两次探测成功后恢复
+	peerClient = cluster.NewPeerClient([]byte(cfg.Cluster.Secret), cfg.Validate.PeerTimeout, cfg.Validate.PeerRetries)
+	failover = cfg.Validate.Failover
+	peerHealth = cluster.NewHealth(port, 2, cfg.Validate.HeartbeatInterval)
+	go peerHealth.Run(ctx)
+	// 节点之间及数量控制服务使用内部协议，以集群密钥签名
+	if cfg.Validate.Protocol == config.ProtocolRPC {
+		rpcPool = rpc.NewPool([]byte(cfg.Cluster.Secret), cfg.Validate.PeerTimeout, cfg.Validate.PeerRetries, cfg.Validate.RPCPoolSize)
+		defer rpcPool.Close()
+	}
+	left := make(chan struct{})
+	go func() {
+		membership.Run(ctx)
+		close(left)
+	}()
+
+	// 连接数据库，用于校验秒杀活动
+	db, err := common.NewMySQLConn()
+	if err != nil {
+		fmt.Println(err)
+	}
+	campaign := repository.NewCampaignManager("campaign", db)
+	campaignService = service.NewCampaignServiceWithRequired(campaign, cfg.Campaign.Required)
+	// 会话令牌，注销列表与前台共享
+	// 每个请求都要校验令牌，注销状态缓存一段时间，避免每次查询数据库
+	revoker := repository.NewRevocationManager("token_revocation", db)
+	if cfg.Token.RevokeCache > 0 {
+		revoker = token.NewCachedRevoker(revoker, cfg.Token.RevokeCache)
+	}
+	tokenManager = token.NewManager([]byte(cfg.Token.Secret), cfg.Token.TTL, revoker)
+
+	rabbitmqSimple := rabbitmq.NewRabbitMQSimple(cfg.RabbitMQ.Queue)
+	rabbitmqSimple.ConfirmTimeout = cfg.RabbitMQ.ConfirmTimeout
+	defer rabbitmqSimple.Destroy()
+	rabbitMQValidate = rabbitmqSimple
+	// 订单事件由下游系统各自的队列订阅，这里只发布
+	if cfg.RabbitMQ.EventExchange != "" {
+		rabbitmqTopic := rabbitmq.NewRabbitMQTopic(cfg.RabbitMQ.EventExchange, model.OrderPlaced, "")
+		rabbitmqTopic.ConfirmTimeout = cfg.RabbitMQ.ConfirmTimeout
+		defer rabbitmqTopic.Destroy()
+		orderEvents = rabbitmqTopic
+	}
 
 	// 过滤器
 	filter := common.NewFilter()
 	//@TODO 优化注册拦截器
-	filter.RegisterFilterURI("/check", Auth)
-	filter.RegisterFilterURI("/checkRight", Auth)
+	// 先按 IP 限流再验证，避免刷接口消耗验证资源，验证通过后再按用户限流
+	filter.RegisterFilterURI("/check", common.NewRateLimitFilter(
+		common.NewRateLimiter(cfg.Validate.IPRate, cfg.Validate.IPBurst),
+		cfg.Validate.ClientIPHeader,
+	))
+	filter.RegisterFilterURI("/check", NewUserAuth(
+		common.NewRateLimiter(cfg.Validate.UserRate, cfg.Validate.UserBurst),
+	))
+	// 节点之间的接口会修改购买记录，只接受集群密钥签名的请求
+	peerAuth := cluster.NewRequestVerifier([]byte(cfg.Cluster.Secret))
+	filter.RegisterFilterURI("/checkRight", peerAuth.Filter)
+	filter.RegisterFilterURI("/releaseRight", peerAuth.Filter)
 	// 2、启动服务
 	http.HandleFunc("/check", filter.Handle(Check))
 	http.HandleFunc("/checkRight", filter.Handle(CheckRight))
+	http.HandleFunc("/releaseRight", filter.Handle(ReleaseRight))
+	// 集群成员接口，心跳和离开消息需要集群密钥签名
+	http.HandleFunc(cluster.HeartbeatPath, membership.HandleHeartbeat)
+	http.HandleFunc(cluster.LeavePath, membership.HandleLeave)
+	http.HandleFunc(cluster.MembersPath, membership.HandleMembers)
+	http.HandleFunc(cluster.HealthPath, cluster.HandleHealth)
+	// 内部协议服务，使用 HTTP 的节点升级期间仍可调用本机 HTTP 接口
+	rpcServer := rpc.NewServer([]byte(cfg.Cluster.Secret))
+	rpcServer.Handle(rpc.OpCheckRight, RPCCheckRight)
+	rpcServer.Handle(rpc.OpReleaseRight, RPCReleaseRight)
+	go func() {
+		if err := rpcServer.ListenAndServe(":" + rpcPort); err != nil {
+			log.Fatal(err)
+		}
+	}()
 	// 启动服务
-	http.ListenAndServe(":8083", nil)
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// 收到退出信号后先通知其它节点离开集群再关闭服务
+	<-left
+	server.Shutdown(context.Background())
+	rpcServer.Close()
 }
@@ -0,0 +1,218 @@
+package main
+
+// 根目录下每个文件都是独立的程序，单独运行：go test validate.go validate_test.go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"litemall/cluster"
+	"litemall/common"
+	"litemall/model"
+	"litemall/rabbitmq"
+	"litemall/repository"
+	"litemall/service"
+)
+
+// fakeCampaigns 返回固定秒杀活动的活动仓库
+type fakeCampaigns struct {
+	repository.ICampaign
+	campaigns []*model.Campaign
+}
+
+func (f *fakeCampaigns) SelectByProductID(productID int64) ([]*model.Campaign, error) {
+	var campaigns []*model.Campaign
+	for _, campaign := range f.campaigns {
+		if campaign.ProductID == productID {
+			campaigns = append(campaigns, campaign)
+		}
+	}
+	return campaigns, nil
+}
+
+// testSecret 测试使用的集群密钥
+var testSecret = []byte("validate-test-cluster-secret-0123")
+
+// fakeGetOne 数量控制服务，记录售出和归还的数量，只接受集群密钥签名的请求
+// 只归还售出过且尚未归还的请求 ID
+type fakeGetOne struct {
+	stock    int
+	sold     int
+	returned int
+	sales    map[string]bool
+	verifier *cluster.RequestVerifier
+	sync.Mutex
+}
+
+func (g *fakeGetOne) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := g.verifier.Verify(r); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	switch r.URL.Path {
+	case "/getOne":
+		if g.sold-g.returned >= g.stock {
+			w.Write([]byte("false"))
+			return
+		}
+		g.sold++
+		g.sales[r.URL.Query().Get("requestID")] = true
+		w.Write([]byte("true"))
+	case "/releaseOne":
+		requestID := r.URL.Query().Get("requestID")
+		if !g.sales[requestID] {
+			w.Write([]byte("false"))
+			return
+		}
+		delete(g.sales, requestID)
+		g.returned++
+		w.Write([]byte("true"))
+	}
+}
+
+// failPublisher err 不为 nil 时发布失败，enqueue 为 true 时消息已经入队后才返回错误
+type failPublisher struct {
+	rabbitmq.Publisher
+	err     error
+	enqueue bool
+}
+
+func (p *failPublisher) Publish(body []byte) error {
+	if p.err == nil || p.enqueue {
+		if err := p.Publisher.Publish(body); err != nil {
+			return err
+		}
+	}
+	return p.err
+}
+
+// fakeOrders 记录创建的订单
+type fakeOrders struct {
+	service.IOrderService
+	messages map[string]*model.Message
+	sync.Mutex
+}
+
+func (f *fakeOrders) InsertOrderByMessage(message *model.Message) (int64, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.messages[message.RequestID] = message
+	return int64(len(f.messages)), nil
+}
+
+// check 以用户 uid 请求 /check，返回响应内容
+func check(uid string, productID int64) string {
+	r := httptest.NewRequest(http.MethodGet, "/check?productID="+strconv.FormatInt(productID, 10), nil)
+	r.AddCookie(&http.Cookie{Name: "uid", Value: uid})
+	r.AddCookie(&http.Cookie{Name: "token", Value: "token"})
+	w := httptest.NewRecorder()
+	Check(w, r)
+	return w.Body.String()
+}
+
+func TestCheckToOrderConsumer(t *testing.T) {
+	const productID = 1
+	now := time.Now()
+	campaignService = service.NewCampaignService(&fakeCampaigns{campaigns: []*model.Campaign{{
+		ID:        1,
+		ProductID: productID,
+		StartTime: now.Add(-time.Hour),
+		EndTime:   now.Add(time.Hour),
+		UserLimit: 1,
+	}}})
+
+	// 单节点，所有用户归属本机
+	localhost = "127.0.0.1"
+	hashConsistent = common.NewConsistent()
+	hashConsistent.Add(localhost)
+	peerClient = cluster.NewPeerClient(testSecret, time.Second, 0)
+
+	getOne := &fakeGetOne{
+		stock:    3,
+		sales:    make(map[string]bool),
+		verifier: cluster.NewRequestVerifier(testSecret),
+	}
+	server := httptest.NewServer(getOne)
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	GetOneIP, GetOnePort, _ = net.SplitHostPort(serverURL.Host)
+
+	broker := rabbitmq.NewMemoryBroker()
+	publisher := &failPublisher{Publisher: broker}
+	rabbitMQValidate = publisher
+
+	if got := check("1", productID); got != "true" {
+		t.Fatalf("用户 1 抢购返回 %s", got)
+	}
+	// 超出每人限购数量
+	if got := check("1", productID); got != "false" {
+		t.Fatalf("用户 1 第二次抢购返回 %s", got)
+	}
+	// 消息确定没有入队时归还数量控制权限并撤销购买记录
+	publisher.err = fmt.Errorf("%w: %w", rabbitmq.ErrNotSent, errors.New("连接已断开"))
+	if got := check("2", productID); got != "false" {
+		t.Fatalf("发布失败时返回 %s", got)
+	}
+	if getOne.returned != 1 || accessControl.GetNewRecord(2, productID) != 0 {
+		t.Fatalf("发布失败后归还 %d 件，购买记录 %d", getOne.returned, accessControl.GetNewRecord(2, productID))
+	}
+	publisher.err = nil
+	if got := check("2", productID); got != "true" {
+		t.Fatalf("用户 2 重试抢购返回 %s", got)
+	}
+	// 确认超时时消息可能已经入队，不能归还，否则会超卖
+	publisher.err = rabbitmq.ErrConfirmTimeout
+	publisher.enqueue = true
+	if got := check("3", productID); got != "false" {
+		t.Fatalf("确认超时时返回 %s", got)
+	}
+	if getOne.returned != 1 || accessControl.GetNewRecord(3, productID) != 1 {
+		t.Fatalf("确认超时后归还 %d 件，购买记录 %d", getOne.returned, accessControl.GetNewRecord(3, productID))
+	}
+	publisher.err = nil
+	// 库存已售完
+	if got := check("4", productID); got != "false" {
+		t.Fatalf("售完后用户 4 抢购返回 %s", got)
+	}
+
+	orders := &fakeOrders{messages: make(map[string]*model.Message)}
+	consumer := rabbitmq.NewOrderConsumer(broker, orders)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		consumer.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("队列中还有 %d 条消息未应答", broker.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	users := make(map[int64]bool)
+	for _, message := range orders.messages {
+		if message.ProductID != productID {
+			t.Errorf("订单商品 %d，期望 %d", message.ProductID, productID)
+		}
+		users[message.UserID] = true
+	}
+	// 确认超时但已入队的消息由消费端创建订单
+	if len(orders.messages) != 3 || !users[1] || !users[2] || !users[3] {
+		t.Errorf("创建了 %d 个订单，用户 %v，期望用户 1、2 和 3 各一个", len(orders.messages), users)
+	}
+}